@@ -0,0 +1,163 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// apiClient talks to the REST API exposed by a running EdgeVPN node
+type apiClient struct {
+	base   string
+	client *http.Client
+}
+
+func newAPIClient(base string) *apiClient {
+	return &apiClient{
+		base:   strings.TrimSuffix(base, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// apiURL returns the base URL of the connection API
+func (c *vpn) apiURL() string {
+	a := c.APIAddress
+	if strings.HasPrefix(a, ":") {
+		a = fmt.Sprintf("127.0.0.1%s", a)
+	}
+	a = strings.Replace(a, "0.0.0.0", "127.0.0.1", 1)
+	if !strings.Contains(a, "://") {
+		a = fmt.Sprintf("http://%s", a)
+	}
+	return a
+}
+
+// api returns a client for the API of the connection, if it is enabled and running
+func (c *vpn) api() (*apiClient, error) {
+	if !c.API || c.isProxy() {
+		return nil, fmt.Errorf("the API is not enabled for '%s'", c.Name)
+	}
+	if !c.isAlive() {
+		return nil, fmt.Errorf("'%s' is not running", c.Name)
+	}
+	return newAPIClient(c.apiURL()), nil
+}
+
+func (a *apiClient) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(dat)
+	}
+
+	req, err := http.NewRequest(method, a.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(dat)))
+	}
+
+	if out == nil || len(dat) == 0 {
+		return nil
+	}
+	return json.Unmarshal(dat, out)
+}
+
+func (a *apiClient) get(path string, out interface{}) error {
+	return a.do(http.MethodGet, path, nil, out)
+}
+
+type dnsRecord struct {
+	Regex   string            `json:"Regex"`
+	Records map[string]string `json:"Records"`
+}
+
+func (a *apiClient) dnsRecords() (records []dnsRecord, err error) {
+	err = a.get("/api/dns", &records)
+	return
+}
+
+func (a *apiClient) addDNSRecord(r dnsRecord) error {
+	return a.do(http.MethodPost, "/api/dns", r, nil)
+}
+
+func (a *apiClient) deleteDNSRecord(regex string) error {
+	return a.deleteLedgerKey("dns", regex)
+}
+
+func (a *apiClient) deleteLedgerKey(bucket, key string) error {
+	return a.do(http.MethodDelete, fmt.Sprintf("/api/ledger/%s/%s", url.PathEscape(bucket), url.PathEscape(key)), nil, nil)
+}
+
+type block struct {
+	Index     int    `json:"Index"`
+	Timestamp string `json:"Timestamp"`
+	Hash      string `json:"Hash"`
+	PrevHash  string `json:"PrevHash"`
+}
+
+// ledger returns the current content of the shared ledger, by bucket and key
+func (a *apiClient) ledger() (ledger map[string]map[string]json.RawMessage, err error) {
+	err = a.get("/api/ledger", &ledger)
+	return
+}
+
+// lastBlock returns the latest block of the blockchain
+func (a *apiClient) lastBlock() (b block, err error) {
+	err = a.get("/api/blockchain", &b)
+	return
+}
+
+type machine struct {
+	PeerID   string `json:"PeerID"`
+	Hostname string `json:"Hostname"`
+	OS       string `json:"OS"`
+	Arch     string `json:"Arch"`
+	Version  string `json:"Version"`
+	Address  string `json:"Address"`
+}
+
+// machines returns the nodes of the network announced in the ledger
+func (a *apiClient) machines() (m []machine, err error) {
+	err = a.get("/api/machines", &m)
+	return
+}
@@ -0,0 +1,509 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	process "github.com/mudler/go-processmanager"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	bundleMagic     = "EDGEVPN-BUNDLE-2"
+	bundleExtension = ".edgevpn-bundle"
+	bundleSaltSize  = 16
+
+	// scrypt cost parameters of the bundle key
+	bundleScryptN = 1 << 15
+	bundleScryptR = 8
+	bundleScryptP = 1
+
+	bundleProfiles = "profiles"
+	bundleRuntimes = "bin"
+	bundleLogs     = "logs"
+)
+
+// runtimeName matches the names of the downloaded runtimes, as written by binaryVersion
+var runtimeName = regexp.MustCompile(`^edgevpn-v[0-9]+\.[0-9]+\.[0-9]+([-+][0-9A-Za-z.+-]+)?$`)
+
+// bundleCipher returns the cipher of a bundle, with a key derived from passphrase with scrypt
+func bundleCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, bundleScryptN, bundleScryptR, bundleScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptBundle encrypts data with a key derived from passphrase
+func encryptBundle(data []byte, passphrase string) ([]byte, error) {
+	salt := make([]byte, bundleSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := bundleCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(bundleMagic), salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, []byte(bundleMagic)), nil
+}
+
+// decryptBundle decrypts a bundle created by encryptBundle
+func decryptBundle(data []byte, passphrase string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(bundleMagic)) {
+		return nil, fmt.Errorf("not an EdgeVPN bundle")
+	}
+	data = data[len(bundleMagic):]
+	if len(data) < bundleSaltSize {
+		return nil, fmt.Errorf("the bundle is truncated")
+	}
+	gcm, err := bundleCipher(passphrase, data[:bundleSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[bundleSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("the bundle is truncated")
+	}
+	out, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(bundleMagic))
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted bundle")
+	}
+	return out, nil
+}
+
+// bundle is the content of an exported archive, by path in the archive
+type bundle map[string][]byte
+
+// newBundle collects the profiles of conns, and optionally the downloaded
+// runtimes and the logs of the connections.
+func newBundle(conns []*vpn, runtimes, logs bool) (bundle, error) {
+	b := bundle{}
+	for _, c := range conns {
+		dat, err := ioutil.ReadFile(filepath.Join(c.stateDir, "data"))
+		if err != nil {
+			return nil, err
+		}
+		b[path.Join(bundleProfiles, c.ID+".json")] = dat
+
+		if !logs {
+			continue
+		}
+		pr := process.New(process.WithStateDir(c.processDir()))
+		for _, f := range []string{pr.StdoutPath(), pr.StderrPath()} {
+			if dat, err := ioutil.ReadFile(f); err == nil {
+				b[path.Join(bundleLogs, c.ID, filepath.Base(f))] = dat
+			}
+		}
+	}
+
+	if runtimes {
+		for _, v := range availableVersions() {
+			dat, err := ioutil.ReadFile(binaryVersion(v))
+			if err != nil {
+				return nil, err
+			}
+			b[path.Join(bundleRuntimes, filepath.Base(binaryVersion(v)))] = dat
+		}
+	}
+	return b, nil
+}
+
+func (b bundle) marshal() ([]byte, error) {
+	names := []string{}
+	for n := range b {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, n := range names {
+		mode := int64(0600)
+		if strings.HasPrefix(n, bundleRuntimes+"/") {
+			mode = 0755
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: n, Mode: mode, Size: int64(len(b[n]))}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(b[n]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// validBundlePath checks that an archive entry can be restored without
+// escaping the state directory.
+func validBundlePath(p string) bool {
+	parts := strings.Split(p, "/")
+	for _, e := range parts {
+		if e == "" || e == "." || e == ".." || strings.ContainsAny(e, `\:`) {
+			return false
+		}
+	}
+	switch parts[0] {
+	case bundleProfiles:
+		return len(parts) == 2
+	case bundleRuntimes:
+		return len(parts) == 2 && runtimeName.MatchString(parts[1])
+	case bundleLogs:
+		return len(parts) == 3
+	}
+	return false
+}
+
+func unmarshalBundle(data []byte) (bundle, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	b := bundle{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg || !validBundlePath(h.Name) {
+			return nil, fmt.Errorf("invalid entry '%s' in the bundle", h.Name)
+		}
+		dat, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		b[h.Name] = dat
+	}
+	return b, nil
+}
+
+// bundleChange is an action importing a bundle performs
+type bundleChange struct {
+	Description string
+	Overwrite   bool
+	Name        string
+}
+
+// profiles returns the connection profiles in the bundle, by ID
+func (b bundle) profiles() (map[string]*vpn, error) {
+	profiles := map[string]*vpn{}
+	names := map[string]bool{}
+	for n, dat := range b {
+		if path.Dir(n) != bundleProfiles {
+			continue
+		}
+		v, err := parseProfile(dat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile '%s': %w", n, err)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("the bundle contains more than one connection named '%s'", v.Name)
+		}
+		names[v.Name] = true
+		profiles[strings.TrimSuffix(path.Base(n), ".json")] = v
+	}
+	return profiles, nil
+}
+
+// resolve returns the profiles of the bundle with the ID they are restored
+// to, by ID in the bundle. Profiles replace existing ones with the same name,
+// and keep their ID if it is free.
+func (b bundle) resolve(conns []*vpn) (map[string]*vpn, error) {
+	profiles, err := b.profiles()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]string{}
+	ids := map[string]bool{}
+	for _, c := range conns {
+		byName[c.Name] = c.ID
+		ids[c.ID] = true
+	}
+	for id, v := range profiles {
+		switch {
+		case byName[v.Name] != "":
+			v.ID = byName[v.Name]
+		case validID(id) && !ids[id]:
+			v.ID = id
+		default:
+			v.ID = ""
+		}
+	}
+	return profiles, nil
+}
+
+// changes returns what restoring the bundle creates or overwrites
+func (b bundle) changes(conns []*vpn) (changes []bundleChange, err error) {
+	profiles, err := b.resolve(conns)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, c := range conns {
+		existing[c.ID] = true
+	}
+	for _, v := range profiles {
+		changes = append(changes, bundleChange{
+			Description: fmt.Sprintf("connection '%s'", v.Name),
+			Overwrite:   existing[v.ID],
+			Name:        v.Name,
+		})
+	}
+	for n := range b {
+		if path.Dir(n) != bundleRuntimes {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(runtimesDir(), path.Base(n)))
+		changes = append(changes, bundleChange{
+			Description: fmt.Sprintf("runtime %s", path.Base(n)),
+			Overwrite:   err == nil,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Description < changes[j].Description })
+	return
+}
+
+// restore writes the content of the bundle in the state directory
+func (b bundle) restore(conns []*vpn) error {
+	profiles, err := b.resolve(conns)
+	if err != nil {
+		return err
+	}
+	for _, v := range profiles {
+		if err := v.writeJSON(); err != nil {
+			return fmt.Errorf("failed restoring '%s': %w", v.Name, err)
+		}
+	}
+
+	for n, dat := range b {
+		parts := strings.Split(n, "/")
+		var dst string
+		mode := os.FileMode(0600)
+		switch parts[0] {
+		case bundleRuntimes:
+			dst = filepath.Join(runtimesDir(), parts[1])
+			mode = 0755
+		case bundleLogs:
+			v, ok := profiles[parts[1]]
+			if !ok {
+				continue
+			}
+			// Logs are kept apart from the process state, which would make the
+			// connection look failed, or running ones lose their output
+			dst = filepath.Join(v.stateDir, bundleLogs, parts[2])
+		default:
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, dat, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *dashboard) exportBundle(app fyne.App) {
+	passphrase := widget.NewPasswordEntry()
+	confirm := widget.NewPasswordEntry()
+	runtimes := widget.NewCheck("Include downloaded EdgeVPN versions", func(bool) {})
+	logs := widget.NewCheck("Include connection logs", func(bool) {})
+
+	dialog.NewForm("Export all connections", "Export", "Cancel",
+		[]*widget.FormItem{
+			widget.NewFormItem("Passphrase", passphrase),
+			widget.NewFormItem("Confirm", confirm),
+			widget.NewFormItem("", runtimes),
+			widget.NewFormItem("", logs),
+		},
+		func(b bool) {
+			if !b {
+				return
+			}
+			if passphrase.Text == "" || passphrase.Text != confirm.Text {
+				errorWindow(fmt.Errorf("the passphrases are empty or don't match"), c.window)
+				return
+			}
+
+			d := dialog.NewFileSave(
+				func(f fyne.URIWriteCloser, e error) {
+					if e != nil {
+						errorWindow(e, c.window)
+						return
+					}
+					if f == nil {
+						return
+					}
+					go func() {
+						defer f.Close()
+						conns := c.connections()
+						b, err := newBundle(conns, runtimes.Checked, logs.Checked)
+						if err != nil {
+							errorWindow(err, c.window)
+							return
+						}
+						dat, err := b.marshal()
+						if err != nil {
+							errorWindow(err, c.window)
+							return
+						}
+						dat, err = encryptBundle(dat, passphrase.Text)
+						if err != nil {
+							errorWindow(err, c.window)
+							return
+						}
+						if _, err := f.Write(dat); err != nil {
+							errorWindow(err, c.window)
+							return
+						}
+						app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("%d connections exported", len(conns))))
+					}()
+				}, c.window)
+			d.SetFileName("connections" + bundleExtension)
+			d.Show()
+		}, c.window).Show()
+}
+
+// previewBundle shows what importing the bundle changes, and restores it on confirmation
+func (c *dashboard) previewBundle(app fyne.App, b bundle) {
+	conns := c.connections()
+	changes, err := b.changes(conns)
+	if err != nil {
+		errorWindow(err, c.window)
+		return
+	}
+
+	lines := []string{}
+	running := map[string]bool{}
+	for _, v := range conns {
+		running[v.Name] = v.isAlive()
+	}
+	for _, ch := range changes {
+		action := "create"
+		if ch.Overwrite {
+			action = "overwrite"
+		}
+		l := fmt.Sprintf("%s %s", action, ch.Description)
+		if ch.Name != "" && running[ch.Name] {
+			l += " (running, restart it to apply)"
+		}
+		lines = append(lines, l)
+	}
+	if len(lines) == 0 {
+		errorWindow(fmt.Errorf("the bundle is empty"), c.window)
+		return
+	}
+
+	list := widget.NewLabel(strings.Join(lines, "\n"))
+	dialog.NewCustomConfirm("Import bundle", "Import", "Cancel",
+		container.NewGridWrap(fyne.NewSize(480, 240), container.NewScroll(list)),
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := b.restore(conns); err != nil {
+				errorWindow(err, c.window)
+			}
+			c.Reload(app)
+			app.SendNotification(fyne.NewNotification("info", "Bundle imported"))
+		}, c.window).Show()
+}
+
+func (c *dashboard) importBundle(app fyne.App) {
+	dialog.NewFileOpen(
+		func(f fyne.URIReadCloser, e error) {
+			if e != nil {
+				errorWindow(e, c.window)
+				return
+			}
+			if f == nil {
+				return
+			}
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			if err != nil {
+				errorWindow(err, c.window)
+				return
+			}
+			c.openBundle(app, data)
+		}, c.window).Show()
+}
+
+// openBundle asks for the passphrase of an encrypted bundle and previews it
+func (c *dashboard) openBundle(app fyne.App, data []byte) {
+	passphrase := widget.NewPasswordEntry()
+	dialog.NewForm("Import bundle", "Decrypt", "Cancel",
+		[]*widget.FormItem{widget.NewFormItem("Passphrase", passphrase)},
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			go func() {
+				dat, err := decryptBundle(data, passphrase.Text)
+				if err != nil {
+					errorWindow(err, c.window)
+					return
+				}
+				b, err := unmarshalBundle(dat)
+				if err != nil {
+					errorWindow(err, c.window)
+					return
+				}
+				c.previewBundle(app, b)
+			}()
+		}, c.window).Show()
+}
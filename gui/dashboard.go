@@ -16,11 +16,12 @@
 package gui
 
 import (
-	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -32,6 +33,33 @@ import (
 
 type dashboard struct {
 	window fyne.Window
+	// visible is false when the window is hidden or was closed.
+	// mu guards them, as the tray changes them from its own goroutines.
+	visible bool
+	closed  bool
+	mu      sync.Mutex
+	// reloadMu serializes the updates of the content of the window
+	reloadMu sync.Mutex
+
+	// controls of the connections, kept across reloads
+	search              *widget.Entry
+	filter, order, view *widget.Select
+	results             *fyne.Container
+	collapsed           map[string]bool
+	// conns and their states are loaded on reload, and filtered by the controls
+	conns  []*vpn
+	states map[*vpn]string
+
+	// newer EdgeVPN release found by the update checker, if any, guarded by reloadMu
+	updateAvailable string
+}
+
+// setUpdateAvailable sets the release offered for upgrade, and reloads the dashboard
+func (c *dashboard) setUpdateAvailable(app fyne.App, v string) {
+	c.reloadMu.Lock()
+	c.updateAvailable = v
+	c.reloadMu.Unlock()
+	c.Reload(app)
 }
 
 const welcomeMessage string = `
@@ -42,34 +70,50 @@ Welcome to the EdgeVPN gui. This is a simple utility to control EdgeVPN instance
 This application can be safely closed. VPN connection will keep running in the background.
 `
 
-func (c *dashboard) Reload(app fyne.App) {
+// connections returns all the VPN connections found in the state directory
+func (c *dashboard) connections() []*vpn {
+	return loadConnections(c)
+}
+
+// loadConnections returns all the connection profiles in the state directory
+func loadConnections(p parent) (foundVpns []*vpn) {
 	state := stateDir()
 	os.MkdirAll(state, os.ModePerm)
 
-	readVpn := func() (foundVpns []*vpn) {
-		files, err := ioutil.ReadDir(state)
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, f := range files {
-			if f.IsDir() {
-				if _, err := os.Stat(filepath.Join(state, f.Name(), "data")); err == nil {
-					foundVpns = append(foundVpns, newVPN(filepath.Join(state, f.Name()), c))
-				}
-			}
-		}
+	files, err := ioutil.ReadDir(state)
+	if err != nil {
+		log.Println(err)
 		return
 	}
-
-	genCards := func() (cards []fyne.CanvasObject) {
-		foundVpns := readVpn()
-		for _, v := range foundVpns {
-			cards = append(cards, v.card(app, c.window))
+	for _, f := range files {
+		if f.IsDir() {
+			if _, err := os.Stat(filepath.Join(state, f.Name(), "data")); err == nil {
+				foundVpns = append(foundVpns, newVPN(filepath.Join(state, f.Name()), p))
+			}
 		}
-		return
 	}
+	return
+}
+
+// Reload redraws the dashboard. It can be called from any goroutine.
+func (c *dashboard) Reload(app fyne.App) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
 
-	welcomeText := widget.NewRichTextFromMarkdown(welcomeMessage)
+	readVpn := c.connections
+
+	var header fyne.CanvasObject = widget.NewRichTextFromMarkdown(welcomeMessage)
+	if c.updateAvailable != "" {
+		v := c.updateAvailable
+		upgrade := widget.NewButtonWithIcon(
+			fmt.Sprintf("Upgrade EdgeVPN to %s", v),
+			theme.ViewRefreshIcon(),
+			func() {
+				newUpgrade(app, c, v).showUI()
+			})
+		upgrade.Importance = widget.HighImportance
+		header = container.NewVBox(header, upgrade)
+	}
 
 	addVPN := func() *widget.Button {
 		b := widget.NewButtonWithIcon("Add VPN",
@@ -85,10 +129,54 @@ func (c *dashboard) Reload(app fyne.App) {
 		return widget.NewButtonWithIcon("Generate new VPN",
 			theme.DocumentCreateIcon(),
 			func() {
+				if err := requireRuntime(); err != nil {
+					dialog.NewConfirm(
+						"EdgeVPN not found",
+						fmt.Sprintf("%s.\nDo you want to open the version manager?", err.Error()),
+						func(b bool) {
+							if b {
+								m := &VersionsManager{dashboard: c}
+								m.showUI(app)
+							}
+						}, c.window).Show()
+					return
+				}
 				newVPN("", c).generateUI(app, true)
 			})
 	}
 
+	importQR := func() *widget.Button {
+		return widget.NewButtonWithIcon("Import from QR code",
+			theme.DownloadIcon(),
+			func() {
+				c.importShared(app)
+			})
+	}
+
+	importInvite := func() *widget.Button {
+		return widget.NewButtonWithIcon("Import invite",
+			theme.MailComposeIcon(),
+			func() {
+				c.importInvite(app)
+			})
+	}
+
+	exportAll := func() *widget.Button {
+		return widget.NewButtonWithIcon("Export all",
+			theme.UploadIcon(),
+			func() {
+				c.exportBundle(app)
+			})
+	}
+
+	importBundle := func() *widget.Button {
+		return widget.NewButtonWithIcon("Import bundle",
+			theme.DownloadIcon(),
+			func() {
+				c.importBundle(app)
+			})
+	}
+
 	importVPN := func() *widget.Button {
 		return widget.NewButtonWithIcon("Import new VPN",
 			theme.DownloadIcon(),
@@ -103,21 +191,14 @@ func (c *dashboard) Reload(app fyne.App) {
 						if f == nil {
 							return
 						}
-						v := &vpn{}
-						err := json.NewDecoder(f).Decode(v)
-						if err != nil {
-							errorWindow(e, c.window)
-							return
-						}
-						f.Close()
+						defer f.Close()
 
-						err = v.writeJSON(v.Name)
+						dat, err := ioutil.ReadAll(f)
 						if err != nil {
-							errorWindow(e, c.window)
+							errorWindow(err, c.window)
 							return
 						}
-						c.Reload(app)
-						app.SendNotification(fyne.NewNotification("info", "File saved"))
+						c.importProfile(app, dat, f.URI().Name())
 					}, c.window)
 				d.Show()
 			})
@@ -126,7 +207,7 @@ func (c *dashboard) Reload(app fyne.App) {
 		return widget.NewButtonWithIcon("Manage EdgeVPN versions",
 			resources.GetResource(resources.EdgeVPNIcon, "manage"),
 			func() {
-				m := &VersionsManager{}
+				m := &VersionsManager{dashboard: c}
 				m.showUI(app)
 			})
 	}
@@ -138,41 +219,55 @@ func (c *dashboard) Reload(app fyne.App) {
 			about(app)
 		})
 
-	if len(readVpn()) == 0 {
+	settingsButton := widget.NewButtonWithIcon("Settings",
+		theme.SettingsIcon(),
+		func() {
+			showSettings(app)
+		})
+
+	c.conns = readVpn()
+	c.states = map[*vpn]string{}
+	for _, v := range c.conns {
+		c.states[v], _ = v.connectionState()
+	}
+
+	if len(c.conns) == 0 {
 		c.window.SetContent(
 			container.NewBorder(
-				welcomeText,
+				header,
 				nil,
 				nil,
 				nil,
 				container.NewCenter(container.NewGridWithColumns(
 					1,
-					noVPN, addVPN(), generateVPN(), importVPN(), downloadEdgeVPN(),
-					aboutButton,
+					noVPN, addVPN(), generateVPN(), importVPN(), importQR(), importInvite(), importBundle(), downloadEdgeVPN(),
+					settingsButton, aboutButton,
 				)),
 			),
 		)
 		c.window.Resize(fyne.NewSize(640, 640))
 	} else {
-		cards := genCards()
-		grid := container.NewAdaptiveGrid(3, cards...)
+		controls := c.controls(app)
+		c.results = container.NewVBox()
+		c.showResults(app)
 		acc := widget.NewAccordion(
 			widget.NewAccordionItem(
 				"Create, Import ...",
 				container.NewGridWithColumns(
 					4,
-					addVPN(), generateVPN(), downloadEdgeVPN(), importVPN(),
+					addVPN(), generateVPN(), downloadEdgeVPN(), importVPN(), importQR(), importInvite(),
+					importBundle(), exportAll(), settingsButton,
 				),
 			),
 		)
 		c.window.Resize(fyne.NewSize(640, 640))
 
-		//c.window.Resize(grid.Layout.MinSize(append(cards, acc, welcomeText, layout.NewSpacer())))
-		b := container.NewVScroll(container.NewVBox(grid))
+		//c.window.Resize(grid.Layout.MinSize(append(cards, acc, header, layout.NewSpacer())))
+		b := container.NewVScroll(c.results)
 
 		c.window.SetContent(
 			container.NewBorder(
-				welcomeText,
+				container.NewVBox(header, controls),
 				acc,
 				nil,
 				nil,
@@ -183,12 +278,80 @@ func (c *dashboard) Reload(app fyne.App) {
 
 }
 
-func (c *dashboard) loadUI(app fyne.App) {
-	c.window = app.NewWindow("EdgeVPN")
+// newWindow creates the window of the dashboard, with c.mu held
+func (c *dashboard) newWindow(app fyne.App) {
+	w := app.NewWindow("EdgeVPN")
+	c.reloadMu.Lock()
+	c.window = w
+	c.reloadMu.Unlock()
+	c.closed = false
+	w.SetOnClosed(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.visible = false
+		c.closed = true
+	})
+	// The dashboard can be opened again from the tray, or by launching the application again
+	w.SetCloseIntercept(func() {
+		if currentSettings().CloseToTray {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			w.Hide()
+			c.visible = false
+			return
+		}
+		w.Close()
+	})
 	c.Reload(app)
-	c.window.SetPadded(true)
-	c.window.CenterOnScreen()
+	w.SetPadded(true)
+	w.CenterOnScreen()
+}
+
+// isVisible returns true if the dashboard is shown
+func (c *dashboard) isVisible() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.visible
+}
+
+// show shows the dashboard, opening it again if its window was closed
+func (c *dashboard) show(app fyne.App) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		c.newWindow(app)
+	}
 	c.window.Show()
+	c.visible = true
+}
+
+// toggle shows the dashboard if hidden, and hides it otherwise
+func (c *dashboard) toggle(app fyne.App) {
+	c.mu.Lock()
+	if c.visible {
+		c.window.Hide()
+		c.visible = false
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.show(app)
+}
+
+func (c *dashboard) loadUI(app fyne.App) {
+	c.mu.Lock()
+	c.newWindow(app)
+	c.mu.Unlock()
+	c.show(app)
+
+	// Cards use the state colors of the theme
+	changes := make(chan fyne.Settings)
+	app.Settings().AddChangeListener(changes)
+	go func() {
+		for range changes {
+			c.Reload(app)
+		}
+	}()
 
 	if !isInstalled("edgevpn") {
 		if len(availableVersions()) != 0 {
@@ -199,7 +362,9 @@ func (c *dashboard) loadUI(app fyne.App) {
 			"EdgeVPN was not found in the system, proceed to download?",
 			func(b bool) {
 				if b {
-					DownloadAndInstall(app, c.window, "")
+					DownloadAndInstall(app, c.window, "", func(error) {
+						c.Reload(app)
+					})
 				}
 			},
 			c.window,
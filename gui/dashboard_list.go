@@ -0,0 +1,252 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	filterAll = "All"
+
+	sortName     = "Name"
+	sortLastUsed = "Last used"
+	sortState    = "State"
+
+	viewCards = "Cards"
+	viewList  = "List"
+
+	// untagged is the group of the connections without tags
+	untagged = "Untagged"
+)
+
+var (
+	stateFilters = []string{filterAll, "Running", "Stopped", "Failed"}
+	sortOrders   = []string{sortName, sortLastUsed, sortState}
+	views        = []string{viewCards, viewList}
+
+	// stateOrder sorts running connections first, then the failed ones
+	stateOrder = map[string]int{"running": 0, "failed": 1, "stopped": 2}
+)
+
+// parseTags splits a comma separated list of tags
+func parseTags(s string) (tags []string) {
+	seen := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		tags = append(tags, t)
+	}
+	return
+}
+
+// matches returns true if the name, address or tags of the connection contain query
+func (c *vpn) matches(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return true
+	}
+	for _, f := range append([]string{c.Name, c.IP, c.Interface, c.ProxyAddress}, c.Tags...) {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortConnections sorts conns by order. states are the states of the connections.
+func sortConnections(conns []*vpn, states map[*vpn]string, order string) {
+	byName := func(a, b *vpn) bool {
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	}
+	sort.SliceStable(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		switch order {
+		case sortLastUsed:
+			if a.LastUsed != b.LastUsed {
+				return a.LastUsed > b.LastUsed
+			}
+		case sortState:
+			if stateOrder[states[a]] != stateOrder[states[b]] {
+				return stateOrder[states[a]] < stateOrder[states[b]]
+			}
+		}
+		return byName(a, b)
+	})
+}
+
+// groupConnections returns the connections by tag, and the sorted tags.
+// Connections with more tags are in each of their groups.
+func groupConnections(conns []*vpn) (map[string][]*vpn, []string) {
+	groups := map[string][]*vpn{}
+	for _, v := range conns {
+		if len(v.Tags) == 0 {
+			groups[untagged] = append(groups[untagged], v)
+		}
+		for _, t := range v.Tags {
+			groups[t] = append(groups[t], v)
+		}
+	}
+
+	names := []string{}
+	for n := range groups {
+		if n != untagged {
+			names = append(names, n)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	if _, ok := groups[untagged]; ok {
+		names = append(names, untagged)
+	}
+	return groups, names
+}
+
+// controls returns the search, filter, sort and view controls of the dashboard.
+// They are created once, to keep their state when the dashboard is reloaded.
+func (c *dashboard) controls(app fyne.App) fyne.CanvasObject {
+	if c.search == nil {
+		s := currentSettings()
+		refresh := func(string) { c.refreshResults(app) }
+
+		c.search = widget.NewEntry()
+		c.search.SetPlaceHolder("Search name, address or tag")
+		c.search.OnChanged = refresh
+
+		c.filter = widget.NewSelect(stateFilters, nil)
+		c.filter.SetSelected(filterAll)
+		c.filter.OnChanged = refresh
+
+		c.order = widget.NewSelect(sortOrders, nil)
+		c.order.SetSelected(s.DashboardSort)
+		if c.order.Selected == "" {
+			c.order.SetSelected(sortName)
+		}
+		c.order.OnChanged = func(string) {
+			c.saveView()
+			c.refreshResults(app)
+		}
+
+		c.view = widget.NewSelect(views, nil)
+		c.view.SetSelected(s.DashboardView)
+		if c.view.Selected == "" {
+			c.view.SetSelected(viewCards)
+		}
+		c.view.OnChanged = c.order.OnChanged
+
+		c.collapsed = map[string]bool{}
+	}
+	return container.NewBorder(nil, nil, nil,
+		container.NewHBox(c.filter, c.order, c.view),
+		c.search,
+	)
+}
+
+// saveView remembers the sort order and the view of the dashboard
+func (c *dashboard) saveView() {
+	s := currentSettings()
+	s.DashboardSort = c.order.Selected
+	s.DashboardView = c.view.Selected
+	if err := saveSettings(s); err != nil {
+		log.Println(err)
+	}
+}
+
+// refreshResults shows the connections matching the controls
+func (c *dashboard) refreshResults(app fyne.App) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	c.showResults(app)
+}
+
+// showResults shows the connections matching the controls, with c.reloadMu held
+func (c *dashboard) showResults(app fyne.App) {
+	if c.results == nil {
+		return
+	}
+
+	conns := []*vpn{}
+	for _, v := range c.conns {
+		if c.filter.Selected != filterAll && c.states[v] != strings.ToLower(c.filter.Selected) {
+			continue
+		}
+		if v.matches(c.search.Text) {
+			conns = append(conns, v)
+		}
+	}
+	sortConnections(conns, c.states, c.order.Selected)
+
+	objs := []fyne.CanvasObject{}
+	groups, names := groupConnections(conns)
+	switch {
+	case len(conns) == 0:
+		objs = append(objs, widget.NewLabel("No connection matches the search"))
+	case len(names) == 1 && names[0] == untagged:
+		objs = append(objs, c.group(app, conns))
+	default:
+		for _, n := range names {
+			objs = append(objs, c.groupHeader(app, n, len(groups[n])))
+			if !c.collapsed[n] {
+				objs = append(objs, c.group(app, groups[n]))
+			}
+		}
+	}
+
+	c.results.Objects = objs
+	c.results.Refresh()
+}
+
+// groupHeader returns the header of a group, which collapses or expands it
+func (c *dashboard) groupHeader(app fyne.App, name string, count int) fyne.CanvasObject {
+	icon := theme.MenuDropDownIcon()
+	if c.collapsed[name] {
+		icon = theme.MenuExpandIcon()
+	}
+	b := widget.NewButtonWithIcon(fmt.Sprintf("%s (%d)", name, count), icon, func() {
+		c.collapsed[name] = !c.collapsed[name]
+		c.refreshResults(app)
+	})
+	b.Alignment = widget.ButtonAlignLeading
+	b.Importance = widget.LowImportance
+	return b
+}
+
+// group returns the connections as cards or as a compact list
+func (c *dashboard) group(app fyne.App, conns []*vpn) fyne.CanvasObject {
+	objs := []fyne.CanvasObject{}
+	if c.view.Selected == viewList {
+		for _, v := range conns {
+			objs = append(objs, v.row(app, c.window))
+		}
+		return container.NewVBox(objs...)
+	}
+	for _, v := range conns {
+		objs = append(objs, v.card(app, c.window))
+	}
+	return container.NewAdaptiveGrid(3, objs...)
+}
@@ -0,0 +1,235 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const (
+	appDirName = "edgevpn-gui"
+
+	// stateDirEnv keeps everything in a single directory, as --state-dir
+	stateDirEnv = "EDGEVPN_GUI_STATE_DIR"
+	// portableEnv enables the portable mode, as --portable
+	portableEnv = "EDGEVPN_GUI_PORTABLE"
+	// portableMarker enables the portable mode when found next to the executable
+	portableMarker  = "portable"
+	portableDirName = "edgevpn-gui-data"
+)
+
+// dirs are the directories the GUI stores its files in
+type dirs struct {
+	// config holds the GUI settings
+	config string
+	// state holds the connection profiles and their running state
+	state string
+	// runtimes holds the downloaded EdgeVPN versions
+	runtimes string
+	// cache holds partial downloads
+	cache string
+
+	portable bool
+	// fixed is true if the state directory was given with --state-dir or the environment
+	fixed bool
+}
+
+var (
+	paths     dirs
+	pathsOnce sync.Once
+)
+
+// singleDir returns directories keeping everything in root, as older versions did in ~/.edgevpn
+func singleDir(root string) dirs {
+	return dirs{config: root, state: root, runtimes: filepath.Join(root, "bin"), cache: root}
+}
+
+func xdgDir(env, home string, def ...string) string {
+	if d := os.Getenv(env); filepath.IsAbs(d) {
+		return filepath.Join(d, appDirName)
+	}
+	return filepath.Join(append([]string{home}, append(def, appDirName)...)...)
+}
+
+// executableDir returns the directory of the executable, following symlinks
+func executableDir() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(exe), nil
+}
+
+// portableDir returns the directory next to the executable used in portable mode
+func portableDir() (string, error) {
+	dir, err := executableDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, portableDirName), nil
+}
+
+func isPortable(flag bool) bool {
+	if flag {
+		return true
+	}
+	if b, _ := strconv.ParseBool(os.Getenv(portableEnv)); b {
+		return true
+	}
+	dir, err := executableDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, portableMarker))
+	return err == nil
+}
+
+// resolveDirs returns the directories to use: the state directory given with
+// --state-dir or the environment, the portable directory, or the XDG base
+// directories. Files of older versions in ~/.edgevpn are moved to the latter.
+// A state directory set in the settings replaces the XDG ones.
+func resolveDirs(stateFlag string, portableFlag bool) (dirs, error) {
+	if stateFlag == "" {
+		stateFlag = os.Getenv(stateDirEnv)
+	}
+	if stateFlag != "" {
+		root, err := filepath.Abs(stateFlag)
+		if err != nil {
+			return dirs{}, err
+		}
+		d := singleDir(root)
+		d.fixed = true
+		return d, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if isPortable(portableFlag) || err != nil {
+		root, perr := portableDir()
+		if perr != nil {
+			if err != nil {
+				return dirs{}, fmt.Errorf("can't find a directory for the state: %s, %s", err.Error(), perr.Error())
+			}
+			return dirs{}, perr
+		}
+		d := singleDir(root)
+		d.portable = true
+		return d, nil
+	}
+
+	d := dirs{
+		config:   xdgDir("XDG_CONFIG_HOME", home, ".config"),
+		state:    xdgDir("XDG_STATE_HOME", home, ".local", "state"),
+		runtimes: filepath.Join(xdgDir("XDG_DATA_HOME", home, ".local", "share"), "bin"),
+		cache:    xdgDir("XDG_CACHE_HOME", home, ".cache"),
+	}
+	legacy := filepath.Join(home, ".edgevpn")
+	if err := migrateLegacyDir(legacy, d); err != nil {
+		log.Printf("Keeping %s, failed moving it to %s: %s", legacy, d.state, err.Error())
+		return singleDir(legacy), nil
+	}
+	return withStateSetting(d), nil
+}
+
+// withStateSetting returns d using the state directory set in the settings, if any
+func withStateSetting(d dirs) dirs {
+	s, err := readSettings(d.config)
+	if err != nil {
+		log.Println(err)
+	}
+	if s.StateDir == "" {
+		return d
+	}
+	d.state = s.StateDir
+	d.runtimes = filepath.Join(s.StateDir, "bin")
+	d.cache = s.StateDir
+	return d
+}
+
+// migrateLegacyDir moves the files of ~/.edgevpn to the XDG directories.
+// The whole directory is moved first, so running connections keep their state.
+func migrateLegacyDir(legacy string, d dirs) error {
+	if _, err := os.Stat(legacy); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(d.state); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.state), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(legacy, d.state); err != nil {
+		return err
+	}
+
+	for src, dst := range map[string]string{
+		filepath.Join(d.state, "bin"):       d.runtimes,
+		filepath.Join(d.state, "downloads"): filepath.Join(d.cache, "downloads"),
+	} {
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+		if err := os.Rename(src, dst); err != nil {
+			log.Printf("Failed moving %s to %s: %s", src, dst, err.Error())
+		}
+	}
+	return nil
+}
+
+// setDirs sets the directories used by the GUI. It must be called before any of them is used.
+func setDirs(d dirs) {
+	pathsOnce.Do(func() {
+		paths = d
+	})
+}
+
+func currentDirs() dirs {
+	pathsOnce.Do(func() {
+		d, err := resolveDirs("", false)
+		if err != nil {
+			log.Println(err)
+			d = singleDir(filepath.Join(os.TempDir(), appDirName))
+		}
+		paths = d
+	})
+	return paths
+}
+
+func stateDir() string {
+	return currentDirs().state
+}
+
+func configDir() string {
+	return currentDirs().config
+}
+
+func runtimesDir() string {
+	return currentDirs().runtimes
+}
+
+func cacheDir() string {
+	return currentDirs().cache
+}
@@ -0,0 +1,338 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const defaultDNSAddress = ":53"
+
+var (
+	defaultDNSForwardServers = []string{"8.8.8.8:53", "1.1.1.1:53"}
+	dnsRecordTypes           = []string{"A", "AAAA", "CNAME", "TXT", "MX", "SRV", "PTR"}
+)
+
+func (c *vpn) validateDNS() error {
+	if !c.DNS {
+		return nil
+	}
+	if err := validateAddress("DNS listen", c.DNSAddress); err != nil {
+		return err
+	}
+	for _, s := range c.DNSForwardServers {
+		if err := validateAddress("DNS forward server", s); err != nil {
+			return err
+		}
+		if host, _, _ := net.SplitHostPort(s); host == "" {
+			return fmt.Errorf("invalid DNS forward server '%s': a host is required", s)
+		}
+	}
+	if c.SplitDNS {
+		domain := strings.TrimPrefix(c.SplitDNSDomain, "~")
+		if domain == "" {
+			return fmt.Errorf("a domain is required to configure split DNS")
+		}
+		if !validHostname.MatchString(domain) {
+			return fmt.Errorf("invalid split DNS domain '%s'", c.SplitDNSDomain)
+		}
+	}
+	return nil
+}
+
+// dnsFlags returns the EdgeVPN flags to configure the embedded DNS server
+func (c *vpn) dnsFlags() []string {
+	if !c.DNS || c.isProxy() {
+		return nil
+	}
+	flags := []string{"--dns", c.DNSAddress, fmt.Sprintf("--dns-forwarder=%t", c.DNSForwarder)}
+	for _, s := range c.DNSForwardServers {
+		flags = append(flags, "--dns-forward-server", s)
+	}
+	return flags
+}
+
+// dnsServer returns the address of the embedded DNS server, reachable from the host
+func (c *vpn) dnsServer() string {
+	host, port, err := net.SplitHostPort(c.DNSAddress)
+	if err != nil {
+		return c.DNSAddress
+	}
+	if host == "" || host == "0.0.0.0" {
+		if ip, _, err := net.ParseCIDR(c.IP); err == nil {
+			host = ip.String()
+		}
+	}
+	if port == "53" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// configureSplitDNS routes queries for the split DNS domain to the
+// embedded DNS server with systemd-resolved.
+func (c *vpn) configureSplitDNS() error {
+	if !c.DNS || !c.SplitDNS || c.isProxy() {
+		return nil
+	}
+
+	domain := "~" + strings.TrimPrefix(c.SplitDNSDomain, "~")
+	for _, args := range [][]string{
+		{"resolvectl", "dns", c.Interface, c.dnsServer()},
+		{"resolvectl", "domain", c.Interface, domain},
+	} {
+		out, err := exec.Command("/usr/bin/pkexec", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed configuring split DNS: %s", strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// revertSplitDNS removes the split DNS configuration of the interface
+func (c *vpn) revertSplitDNS() error {
+	if !c.DNS || !c.SplitDNS || c.isProxy() {
+		return nil
+	}
+
+	out, err := exec.Command("/usr/bin/pkexec", "resolvectl", "revert", c.Interface).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed reverting split DNS: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c *vpn) dnsSettingsForm(app fyne.App, w fyne.Window) fyne.CanvasObject {
+	address := widget.NewEntry()
+	address.SetText(c.DNSAddress)
+	if address.Text == "" {
+		address.SetText(defaultDNSAddress)
+	}
+
+	servers := c.DNSForwardServers
+	if len(servers) == 0 {
+		servers = defaultDNSForwardServers
+	}
+	forwardServers := widget.NewEntry()
+	forwardServers.SetText(strings.Join(servers, ", "))
+	forwarder := widget.NewCheck("Forward unknown domains", func(b bool) {
+		if b {
+			forwardServers.Enable()
+		} else {
+			forwardServers.Disable()
+		}
+	})
+	forwarder.SetChecked(c.DNSForwarder || c.DNSAddress == "")
+
+	domain := widget.NewEntry()
+	domain.SetText(c.SplitDNSDomain)
+	domain.SetPlaceHolder("e.g. edgevpn.lan")
+	splitDNS := widget.NewCheck("Configure systemd-resolved split DNS", func(b bool) {
+		if b {
+			domain.Enable()
+		} else {
+			domain.Disable()
+		}
+	})
+	splitDNS.SetChecked(c.SplitDNS)
+	if !c.SplitDNS {
+		domain.Disable()
+	}
+
+	enabled := widget.NewCheck("Embedded DNS server", func(bool) {})
+	enabled.SetChecked(c.DNS)
+
+	save := widget.NewButtonWithIcon("Save DNS settings",
+		theme.DocumentSaveIcon(),
+		func() {
+			d := *c
+			d.DNS = enabled.Checked
+			d.DNSAddress = address.Text
+			d.DNSForwarder = forwarder.Checked
+			d.DNSForwardServers = []string{}
+			for _, s := range strings.Split(forwardServers.Text, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					d.DNSForwardServers = append(d.DNSForwardServers, s)
+				}
+			}
+			d.SplitDNS = splitDNS.Checked
+			d.SplitDNSDomain = domain.Text
+
+			if err := d.writeJSON(); err != nil {
+				errorWindow(err, w)
+				return
+			}
+			c.loadJSON()
+			msg := "DNS settings saved"
+			if c.isAlive() {
+				msg += ", restart the connection to apply them"
+			}
+			app.SendNotification(fyne.NewNotification("info", msg))
+		})
+
+	return container.NewVBox(
+		widget.NewForm(
+			widget.NewFormItem("", enabled),
+			widget.NewFormItem("Listen Address", address),
+			widget.NewFormItem("", forwarder),
+			widget.NewFormItem("Forward servers", forwardServers),
+			widget.NewFormItem("", splitDNS),
+			widget.NewFormItem("Domain", domain),
+		),
+		save,
+	)
+}
+
+func (c *vpn) dnsRecordsUI(w fyne.Window) fyne.CanvasObject {
+	list := container.NewVBox()
+
+	var refresh func()
+	refresh = func() {
+		api, err := c.api()
+		if err != nil {
+			list.Objects = []fyne.CanvasObject{widget.NewLabel(fmt.Sprintf("DNS records are not available: %s", err.Error()))}
+			list.Refresh()
+			return
+		}
+		records, err := api.dnsRecords()
+		if err != nil {
+			list.Objects = []fyne.CanvasObject{widget.NewLabel(fmt.Sprintf("Failed retrieving DNS records: %s", err.Error()))}
+			list.Refresh()
+			return
+		}
+
+		objs := []fyne.CanvasObject{}
+		for _, r := range records {
+			r := r
+			types := []string{}
+			for t := range r.Records {
+				types = append(types, t)
+			}
+			sort.Strings(types)
+			values := []string{}
+			for _, t := range types {
+				values = append(values, fmt.Sprintf("%s %s", t, r.Records[t]))
+			}
+
+			remove := widget.NewButtonWithIcon("",
+				theme.DeleteIcon(),
+				func() {
+					dialog.NewConfirm(
+						"Delete",
+						fmt.Sprintf("Are you sure you want to delete the records for '%s'?", r.Regex),
+						func(b bool) {
+							if !b {
+								return
+							}
+							if err := api.deleteDNSRecord(r.Regex); err != nil {
+								errorWindow(err, w)
+							}
+							refresh()
+						}, w).Show()
+				})
+			remove.Importance = widget.LowImportance
+
+			objs = append(objs, container.NewHBox(
+				widget.NewLabelWithStyle(r.Regex, fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+				widget.NewLabel(strings.Join(values, ", ")),
+				layout.NewSpacer(),
+				remove,
+			))
+		}
+		if len(objs) == 0 {
+			objs = append(objs, widget.NewLabel("No DNS records in the network"))
+		}
+		list.Objects = objs
+		list.Refresh()
+	}
+	refresh()
+
+	regex := widget.NewEntry()
+	regex.SetPlaceHolder("e.g. foo.edgevpn.lan.")
+	recordType := widget.NewSelect(dnsRecordTypes, func(string) {})
+	recordType.SetSelected("A")
+	value := widget.NewEntry()
+
+	add := widget.NewButtonWithIcon("Add record",
+		theme.ContentAddIcon(),
+		func() {
+			api, err := c.api()
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			if _, err := regexp.Compile(regex.Text); err != nil || regex.Text == "" {
+				errorWindow(fmt.Errorf("invalid name '%s': must be a valid regular expression", regex.Text), w)
+				return
+			}
+			if value.Text == "" {
+				errorWindow(fmt.Errorf("record value can't be empty"), w)
+				return
+			}
+			err = api.addDNSRecord(dnsRecord{
+				Regex:   regex.Text,
+				Records: map[string]string{recordType.Selected: value.Text},
+			})
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			regex.SetText("")
+			value.SetText("")
+			refresh()
+		})
+
+	return container.NewBorder(
+		container.NewHBox(
+			widget.NewLabelWithStyle("Records", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			layout.NewSpacer(),
+			widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), refresh),
+		),
+		container.NewVBox(
+			widget.NewForm(
+				widget.NewFormItem("Name", regex),
+				widget.NewFormItem("Type", recordType),
+				widget.NewFormItem("Value", value),
+			),
+			add,
+		),
+		nil,
+		nil,
+		container.NewVScroll(list),
+	)
+}
+
+func (c *vpn) dnsUI(app fyne.App, w fyne.Window) fyne.CanvasObject {
+	return container.NewBorder(
+		container.NewVBox(c.dnsSettingsForm(app, w), widget.NewSeparator()),
+		nil,
+		nil,
+		nil,
+		c.dnsRecordsUI(w),
+	)
+}
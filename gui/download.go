@@ -2,102 +2,336 @@ package gui
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
-	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/cavaliercoder/grab"
 	"github.com/mholt/archiver/v3"
 	"github.com/otiai10/copy"
 )
 
-const repoSlug = "mudler/edgevpn"
+const downloadRetries = 3
 
-func Download(app fyne.App, url, dst string) string {
-	// create client
-	client := grab.NewClient()
-	req, _ := grab.NewRequest(dst, url)
+// retryBackoff is the delay before retrying a download, multiplied by the attempt number
+var retryBackoff = 2 * time.Second
 
-	w := app.NewWindow(fmt.Sprintf("Download %s", url))
+// downloads is the download manager of the application
+var downloads *downloadManager
 
-	tt := widget.NewLabel(fmt.Sprintf("Downloading %s in %s", url, dst))
-	progress := widget.NewProgressBar()
-	w.SetContent(container.NewVBox(tt, progress))
+// isTransient returns true if err is worth retrying the download for
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status grab.StatusCodeError
+	if errors.As(err, &status) {
+		return int(status) >= http.StatusInternalServerError || int(status) == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
 
-	//c.window.Resize(fyne.NewSize(200, 300))
-	w.Show()
+func downloadOnce(ctx context.Context, client *grab.Client, url, dst string, progress func(*grab.Response)) (string, error) {
+	req, err := grab.NewRequest(dst, url)
+	if err != nil {
+		return "", err
+	}
 
-	// start download
-	resp := client.Do(req)
+	resp := client.Do(req.WithContext(ctx))
 
-	// start UI loop
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
-
-Loop:
 	for {
 		select {
 		case <-t.C:
-			progress.SetValue(resp.Progress())
+			if progress != nil {
+				progress(resp)
+			}
 		case <-resp.Done:
-			// download is complete
-			break Loop
+			if progress != nil {
+				progress(resp)
+			}
+			return resp.Filename, resp.Err()
 		}
 	}
+}
+
+// download fetches url into the dst directory and returns the path of the downloaded file.
+// Partial downloads left in dst are resumed, and transient failures are retried up to
+// retries times.
+func download(ctx context.Context, client *grab.Client, url, dst string, retries int, progress func(*grab.Response)) (string, error) {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retryBackoff * time.Duration(attempt)):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
 
-	// check for errors
-	if err := resp.Err(); err != nil {
-		errorWindow(err, w)
+		var filename string
+		filename, err = downloadOnce(ctx, client, url, dst, progress)
+		if err == nil {
+			return filename, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !isTransient(err) {
+			return "", err
+		}
+		log.Printf("Download of %s failed (attempt %d/%d): %s", url, attempt+1, retries+1, err.Error())
 	}
-	w.Close()
-	app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Download saved to ./%v", resp.Filename)))
+	return "", err
+}
 
-	return resp.Filename
+func downloadStatus(resp *grab.Response) string {
+	status := humanSize(resp.BytesComplete())
+	if resp.Size > 0 {
+		status += " of " + humanSize(resp.Size)
+	}
+	status += fmt.Sprintf(", %s/s", humanSize(int64(resp.BytesPerSecond())))
+	if eta := resp.ETA(); !eta.IsZero() && !resp.IsComplete() {
+		status += fmt.Sprintf(", %s left", time.Until(eta).Round(time.Second))
+	}
+	return status
 }
 
-func DownloadEdgeVPN(url, dstfile string, app fyne.App, w fyne.Window) {
-	tmpdir, err := ioutil.TempDir("", "edgevpn-gui")
-	if err != nil {
-		errorWindow(err, w)
-		return
+type downloadJob struct {
+	url string
+	dst string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	onDone func(filename string, err error)
+
+	progress *widget.ProgressBar
+	status   *widget.Label
+	row      fyne.CanvasObject
+}
+
+type downloadManager struct {
+	app     fyne.App
+	client  *grab.Client
+	retries int
+
+	// pending are the queued jobs, which are processed in order. wake signals new ones.
+	mu      sync.Mutex
+	pending []*downloadJob
+	wake    chan struct{}
+
+	window fyne.Window
+	list   *fyne.Container
+}
+
+func newDownloadManager(app fyne.App) *downloadManager {
+	m := &downloadManager{
+		app:     app,
+		client:  grab.NewClient(),
+		retries: downloadRetries,
+		wake:    make(chan struct{}, 1),
+		list:    container.NewVBox(),
 	}
-	defer os.RemoveAll(tmpdir)
+	go m.loop()
+	return m
+}
 
-	dst := Download(app, url, tmpdir)
+func (m *downloadManager) loop() {
+	for range m.wake {
+		for j := m.next(); j != nil; j = m.next() {
+			m.process(j)
+		}
+	}
+}
 
-	err = archiver.Unarchive(dst, tmpdir)
-	if err != nil {
-		errorWindow(err, w)
-		return
+// next removes the first job from the queue, and returns nil if it is empty
+func (m *downloadManager) next() *downloadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.pending) == 0 {
+		return nil
 	}
+	j := m.pending[0]
+	m.pending = m.pending[1:]
+	return j
+}
 
-	err = copy.Copy(filepath.Join(tmpdir, "edgevpn"), dstfile)
-	if err != nil {
-		errorWindow(err, w)
+func (m *downloadManager) process(j *downloadJob) {
+	if err := j.ctx.Err(); err != nil {
+		m.finish(j, "", err)
 		return
 	}
+
+	j.status.SetText("Downloading")
+	os.MkdirAll(j.dst, os.ModePerm)
+	filename, err := download(j.ctx, m.client, j.url, j.dst, m.retries, func(resp *grab.Response) {
+		j.progress.SetValue(resp.Progress())
+		j.status.SetText(downloadStatus(resp))
+	})
+	m.finish(j, filename, err)
+}
+
+func (m *downloadManager) finish(j *downloadJob, filename string, err error) {
+	j.cancel()
+	m.list.Remove(j.row)
+	if len(m.list.Objects) == 0 && m.window != nil {
+		m.window.Hide()
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		m.app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Download of %s cancelled", j.url)))
+	case err != nil:
+		m.app.SendNotification(fyne.NewNotification("error", fmt.Sprintf("Download of %s failed: %s", j.url, err.Error())))
+	default:
+		m.app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Download saved to %v", filename)))
+	}
+
+	if j.onDone != nil {
+		j.onDone(filename, err)
+	}
 }
 
-func DownloadAndInstall(app fyne.App, w fyne.Window, version string) {
-	f := newReleaseFinder(context.Background(), "")
+func (m *downloadManager) showUI() {
+	if m.window == nil {
+		m.window = m.app.NewWindow("Downloads")
+		m.window.SetContent(container.NewVScroll(m.list))
+		m.window.Resize(fyne.NewSize(480, 200))
+		m.window.SetCloseIntercept(func() {
+			m.window.Hide()
+		})
+	}
+	m.window.Show()
+}
+
+// enqueue schedules the download of url into the dst directory, without blocking,
+// and returns a function cancelling it. onDone is called with the downloaded file
+// once the download is completed, failed or cancelled.
+func (m *downloadManager) enqueue(url, dst string, onDone func(filename string, err error)) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &downloadJob{
+		url:      url,
+		dst:      dst,
+		ctx:      ctx,
+		cancel:   cancel,
+		onDone:   onDone,
+		progress: widget.NewProgressBar(),
+		status:   widget.NewLabel("Queued"),
+	}
+
+	name := widget.NewLabel(path.Base(url))
+	name.Wrapping = fyne.TextTruncate
+	j.row = container.NewBorder(
+		nil,
+		nil,
+		nil,
+		widget.NewButtonWithIcon("", theme.CancelIcon(), cancel),
+		container.NewVBox(name, j.progress, j.status),
+	)
+	m.list.Add(j.row)
+	m.showUI()
+
+	m.mu.Lock()
+	m.pending = append(m.pending, j)
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+		// The loop is already signalled, and will pick the job up
+	}
+	return cancel
+}
+
+// DownloadEdgeVPN downloads the EdgeVPN archive at url and installs the binary as dstfile.
+// onDone is called once the installation has completed or failed.
+func DownloadEdgeVPN(url, dstfile string, onDone func(error)) {
+	dst := filepath.Join(cacheDir(), "downloads")
+	downloads.enqueue(url, dst, func(archive string, err error) {
+		if err == nil {
+			err = installEdgeVPN(archive, dstfile)
+		}
+		if onDone != nil {
+			onDone(err)
+		}
+	})
+}
 
-	rel, ass, err := f.find(repoSlug, version)
+func installEdgeVPN(archive, dstfile string) error {
+	tmpdir, err := ioutil.TempDir("", "edgevpn-gui")
 	if err != nil {
-		errorWindow(err, w)
-		return
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := archiver.Unarchive(archive, tmpdir); err != nil {
+		return err
+	}
+
+	if err := copy.Copy(filepath.Join(tmpdir, "edgevpn"), dstfile); err != nil {
+		return err
 	}
-	fmt.Println("Found", *ass.Name, *ass.BrowserDownloadURL)
-	DownloadEdgeVPN(*ass.BrowserDownloadURL, binaryVersion(rel.GetName()), app, w)
+
+	return os.Remove(archive)
+}
+
+// DownloadAndInstall downloads and installs the given EdgeVPN version in the background,
+// or the latest stable release if version is empty. Errors are shown in w, and
+// onDone is called once the installation has completed or failed.
+func DownloadAndInstall(app fyne.App, w fyne.Window, version string, onDone func(error)) {
+	done := func(err error) {
+		if err != nil {
+			errorWindow(err, w)
+		}
+		if onDone != nil {
+			onDone(err)
+		}
+	}
+
+	// Looking up the release queries the GitHub API, so it doesn't block the caller either
+	go func() {
+		s := currentSettings()
+		f := newReleaseFinder(context.Background(), s.GitHubToken)
+
+		rel, ass, err := f.find(s.ReleaseRepository, version)
+		if err != nil {
+			done(err)
+			return
+		}
+		if rel == nil {
+			done(fmt.Errorf("no release found for '%s'", s.ReleaseRepository))
+			return
+		}
+
+		log.Println("Found", ass.GetName(), ass.GetBrowserDownloadURL())
+		DownloadEdgeVPN(ass.GetBrowserDownloadURL(), binaryVersion(rel.GetName()), done)
+	}()
 }
 
 type VersionsManager struct {
-	window fyne.Window
+	window    fyne.Window
+	dashboard *dashboard
+
+	showPrereleases bool
+	showDrafts      bool
 }
 
 func inSlice(s string, ss []string) bool {
@@ -109,57 +343,151 @@ func inSlice(s string, ss []string) bool {
 	return false
 }
 
+func releaseNotes(app fyne.App, r release) {
+	w := app.NewWindow(fmt.Sprintf("Release notes %s", r.Name))
+	notes := r.Notes
+	if notes == "" {
+		notes = "No release notes available."
+	}
+	text := widget.NewRichTextFromMarkdown(notes)
+	text.Wrapping = fyne.TextWrapWord
+	w.SetContent(container.NewVScroll(text))
+	w.Resize(fyne.NewSize(640, 480))
+	w.Show()
+}
+
+func (m *VersionsManager) versionCard(app fyne.App, r release, installed bool, usedBy []string) fyne.CanvasObject {
+	v := r.Name
+
+	details := []string{}
+	if !r.Published.IsZero() {
+		details = append(details, fmt.Sprintf("Published %s", r.Published.Format("2006-01-02")))
+	}
+	if r.Draft {
+		details = append(details, "draft")
+	} else if r.Prerelease {
+		details = append(details, "pre-release")
+	}
+	if installed {
+		details = append(details, fmt.Sprintf("installed (%s)", humanSize(installedSize(v))))
+	}
+	if len(usedBy) != 0 {
+		details = append(details, fmt.Sprintf("used by %s", strings.Join(usedBy, ", ")))
+	}
+
+	var b *widget.Button
+	if installed {
+		b = widget.NewButtonWithIcon(
+			"Remove",
+			theme.DeleteIcon(),
+			func() {
+				m.remove(app, v)
+			},
+		)
+	} else {
+		b = widget.NewButtonWithIcon(
+			"Download",
+			theme.DownloadIcon(),
+			func() {
+				DownloadAndInstall(app, m.window, v, func(err error) {
+					m.showUI(app)
+				})
+			},
+		)
+	}
+
+	notes := widget.NewButtonWithIcon(
+		"Notes",
+		theme.DocumentIcon(),
+		func() {
+			releaseNotes(app, r)
+		},
+	)
+	if r.Notes == "" {
+		notes.Disable()
+	}
+
+	return widget.NewCard(v, strings.Join(details, " · "),
+		container.NewHBox(notes, b),
+	)
+}
+
 func (m *VersionsManager) showUI(app fyne.App) {
 
 	if m.window == nil {
 		m.window = app.NewWindow("Version manager")
 	}
-	f := newReleaseFinder(context.Background(), "")
-	versions, _ := f.findAll(repoSlug)
+	s := currentSettings()
+	f := newReleaseFinder(context.Background(), s.GitHubToken)
+	releases, err := f.findAll(s.ReleaseRepository)
+
+	available := availableVersions()
+	inventory := runtimeInventory(m.connections())
+	usedBy := map[string][]string{}
+	for _, r := range inventory {
+		usedBy[r.Version] = r.profileNames()
+	}
 
 	cards := []fyne.CanvasObject{}
+	if err != nil {
+		cards = append(cards, widget.NewLabel(fmt.Sprintf("Failed to retrieve releases: %s", err.Error())))
+	}
 
-	available := availableVersions()
+	remote := []string{}
+	for _, r := range releases {
+		remote = append(remote, r.Name)
+		if r.Draft && !m.showDrafts {
+			continue
+		}
+		if r.Prerelease && !m.showPrereleases && !inSlice(r.Name, available) {
+			continue
+		}
+		cards = append(cards, m.versionCard(app, r, inSlice(r.Name, available), usedBy[r.Name]))
+	}
 
-	for i := range versions {
-		v := versions[i]
-		var b *widget.Button
-		if inSlice(v, available) {
-			b = widget.NewButton(
-				"Remove",
-				func() {
-					dialog.NewConfirm(
-						"Delete",
-						fmt.Sprintf("Are you sure you want to delete version %v?",
-							v),
-						func(b bool) {
-							if b {
-								os.RemoveAll(binaryVersion(v))
-								m.showUI(app)
-							}
-						}, m.window).Show()
-				},
-			)
-		} else {
-			b = widget.NewButton(
-				"Download",
-				func() {
-					DownloadAndInstall(app, m.window, v)
-					m.showUI(app)
-				},
-			)
+	// Versions installed locally that are not available upstream anymore
+	// (or that we couldn't retrieve) are still listed so they can be removed.
+	for i := len(available) - 1; i >= 0; i-- {
+		v := available[i]
+		if !inSlice(v, remote) {
+			cards = append(cards, m.versionCard(app, release{Name: v, Prerelease: isPrerelease(v)}, true, usedBy[v]))
 		}
+	}
 
-		cards = append(cards,
-			widget.NewCard(v, "",
-				b,
-			),
-		)
+	prereleases := widget.NewCheck("Show pre-releases", func(b bool) {
+		if b != m.showPrereleases {
+			m.showPrereleases = b
+			m.showUI(app)
+		}
+	})
+	prereleases.SetChecked(m.showPrereleases)
+	drafts := widget.NewCheck("Show drafts", func(b bool) {
+		if b != m.showDrafts {
+			m.showDrafts = b
+			m.showUI(app)
+		}
+	})
+	drafts.SetChecked(m.showDrafts)
+
+	latest := latestInstalledVersion()
+	if latest == "" {
+		latest = "none"
 	}
 
+	clean := widget.NewButtonWithIcon("Clean unused versions",
+		theme.DeleteIcon(),
+		func() {
+			m.clean(app)
+		})
+
 	m.window.SetContent(
 		container.NewBorder(
-			nil,
+			container.NewVBox(
+				container.NewHBox(prereleases, drafts),
+				widget.NewLabel(fmt.Sprintf("Latest installed: %s", latest)),
+				widget.NewLabel(fmt.Sprintf("Disk usage: %s in %d versions", humanSize(diskUsage(inventory)), len(inventory))),
+				clean,
+			),
 			nil,
 			nil,
 			nil,
@@ -167,6 +495,6 @@ func (m *VersionsManager) showUI(app fyne.App) {
 		),
 	)
 
-	m.window.Resize(fyne.NewSize(300, 300))
+	m.window.Resize(fyne.NewSize(480, 480))
 	m.window.Show()
 }
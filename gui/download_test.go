@@ -0,0 +1,184 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fyne.io/fyne/v2/test"
+)
+
+var payload = bytes.Repeat([]byte("edgevpn"), 64*1024)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "edgevpn-gui-test")
+	if err != nil {
+		panic(err)
+	}
+	setDirs(singleDir(dir))
+	retryBackoff = 10 * time.Millisecond
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type result struct {
+	filename string
+	err      error
+}
+
+// waitResult returns the result sent to done, failing the test if it takes too long
+func waitResult(t *testing.T, done chan result) result {
+	t.Helper()
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the download")
+	}
+	return result{}
+}
+
+func enqueue(m *downloadManager, url, dst string) (chan result, context.CancelFunc) {
+	done := make(chan result, 1)
+	cancel := m.enqueue(url, dst, func(filename string, err error) {
+		done <- result{filename, err}
+	})
+	return done, cancel
+}
+
+func serveContent(w http.ResponseWriter, r *http.Request) {
+	http.ServeContent(w, r, "edgevpn.tar.gz", time.Time{}, bytes.NewReader(payload))
+}
+
+func checkDownloaded(t *testing.T, r result) {
+	t.Helper()
+	if r.err != nil {
+		t.Fatalf("download failed: %s", r.err)
+	}
+	dat, err := ioutil.ReadFile(r.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dat, payload) {
+		t.Fatalf("downloaded %d bytes, expected %d", len(dat), len(payload))
+	}
+}
+
+func TestDownloadRetriesTransientErrors(t *testing.T) {
+	var failures int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && atomic.AddInt32(&failures, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		serveContent(w, r)
+	}))
+	defer srv.Close()
+
+	done, _ := enqueue(newDownloadManager(test.NewApp()), srv.URL+"/edgevpn.tar.gz", t.TempDir())
+	checkDownloaded(t, waitResult(t, done))
+	if atomic.LoadInt32(&failures) < 2 {
+		t.Fatal("the download wasn't retried")
+	}
+}
+
+func TestDownloadResumesPartialFiles(t *testing.T) {
+	var ranged int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			atomic.StoreInt32(&ranged, 1)
+		}
+		serveContent(w, r)
+	}))
+	defer srv.Close()
+
+	dst := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dst, "edgevpn.tar.gz"), payload[:len(payload)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done, _ := enqueue(newDownloadManager(test.NewApp()), srv.URL+"/edgevpn.tar.gz", dst)
+	checkDownloaded(t, waitResult(t, done))
+	if atomic.LoadInt32(&ranged) == 0 {
+		t.Fatal("the partial file wasn't resumed with a range request")
+	}
+}
+
+func TestDownloadCancel(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		if r.Method != http.MethodGet {
+			return
+		}
+		w.Write(payload[:1024])
+		w.(http.Flusher).Flush()
+		close(started)
+		// Stall until the client goes away
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	done, cancel := enqueue(newDownloadManager(test.NewApp()), srv.URL+"/edgevpn.tar.gz", t.TempDir())
+	select {
+	case <-started:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for the transfer to start")
+	}
+	cancel()
+
+	if r := waitResult(t, done); !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("expected the download to be cancelled, got %v", r.err)
+	}
+}
+
+func TestDownloadEdgeVPNFailure(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	downloads = newDownloadManager(test.NewApp())
+	dstfile := filepath.Join(t.TempDir(), "edgevpn")
+	done := make(chan result, 1)
+	DownloadEdgeVPN(srv.URL+"/edgevpn.tar.gz", dstfile, func(err error) {
+		done <- result{err: err}
+	})
+
+	if r := waitResult(t, done); r.err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	if n := atomic.LoadInt32(&requests); n > 2 {
+		t.Fatalf("a not found error was retried: %d requests", n)
+	}
+	if _, err := os.Stat(dstfile); !os.IsNotExist(err) {
+		t.Fatalf("nothing should be installed when the download fails: %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	process "github.com/mudler/go-processmanager"
+)
+
+const (
+	transferSend    = "send"
+	transferReceive = "receive"
+)
+
+// transfer is a file shared or being received over the network
+type transfer struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+func (c *vpn) transfersDir() string {
+	return filepath.Join(c.stateDir, "files")
+}
+
+func (c *vpn) transferDir(t transfer) string {
+	return filepath.Join(c.transfersDir(), fmt.Sprintf("%s-%s", t.Kind, safeName(t.Name)))
+}
+
+func (c *vpn) transferAlive(t transfer) bool {
+	return process.New(process.WithStateDir(c.transferDir(t))).IsAlive()
+}
+
+// transfers returns the file transfers of the connection
+func (c *vpn) transfers() (transfers []transfer) {
+	dirs, _ := ioutil.ReadDir(c.transfersDir())
+	for _, d := range dirs {
+		dat, err := ioutil.ReadFile(filepath.Join(c.transfersDir(), d.Name(), "transfer.json"))
+		if err != nil {
+			continue
+		}
+		t := transfer{}
+		if err := json.Unmarshal(dat, &t); err == nil {
+			transfers = append(transfers, t)
+		}
+	}
+	return
+}
+
+func (c *vpn) startTransfer(app fyne.App, t transfer) error {
+	if t.Name == "" {
+		return fmt.Errorf("file name can't be empty")
+	}
+	if c.transferAlive(t) {
+		return fmt.Errorf("'%s' is already being transferred", t.Name)
+	}
+
+	dir := c.transferDir(t)
+	os.RemoveAll(dir)
+	if _, err := c.runCommand(dir, "file-"+t.Kind, t.Name, t.Path); err != nil {
+		return err
+	}
+
+	dat, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "transfer.json"), dat, os.ModePerm); err != nil {
+		return err
+	}
+
+	go c.watchTransfer(app, t)
+	return nil
+}
+
+// watchTransfer notifies when a transfer terminates
+func (c *vpn) watchTransfer(app fyne.App, t transfer) {
+	for c.transferAlive(t) {
+		time.Sleep(time.Second)
+	}
+	if _, err := os.Stat(c.transferDir(t)); err != nil {
+		// Stopped by the user
+		return
+	}
+
+	exit, _ := process.New(process.WithStateDir(c.transferDir(t))).ExitCode()
+	switch {
+	case t.Kind == transferReceive && strings.TrimSpace(exit) == "0":
+		app.SendNotification(fyne.NewNotification("file received", fmt.Sprintf("'%s' saved to %s", t.Name, t.Path)))
+	case t.Kind == transferReceive:
+		app.SendNotification(fyne.NewNotification("transfer failed", fmt.Sprintf("failed receiving '%s'", t.Name)))
+	default:
+		app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("'%s' is not shared anymore", t.Name)))
+	}
+}
+
+func (c *vpn) stopTransfer(t transfer) {
+	stopCommand(c.transferDir(t))
+}
+
+func (c *vpn) stopTransfers() {
+	for _, t := range c.transfers() {
+		c.stopTransfer(t)
+	}
+}
+
+func lastLine(path string) string {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(dat)), "\n")
+	return lines[len(lines)-1]
+}
+
+// transferProgress returns a short description of the state of the transfer
+func (c *vpn) transferProgress(t transfer) string {
+	pr := process.New(process.WithStateDir(c.transferDir(t)))
+
+	status := "shared"
+	if t.Kind == transferReceive {
+		status = "receiving"
+		if info, err := os.Stat(t.Path); err == nil {
+			status = fmt.Sprintf("received %s", humanSize(info.Size()))
+		}
+	}
+	if !pr.IsAlive() {
+		status = "terminated"
+		if exit, err := pr.ExitCode(); err == nil && strings.TrimSpace(exit) == "0" {
+			status = "completed"
+		}
+	}
+
+	if out := lastLine(pr.StderrPath()); out != "" {
+		status += ": " + out
+	} else if out := lastLine(pr.StdoutPath()); out != "" {
+		status += ": " + out
+	}
+	return status
+}
+
+// sendFile picks a file and shares it over the network. Dropping files on the
+// connection isn't supported, as the window drop events need fyne 2.4.
+func (c *vpn) sendFile(app fyne.App, w fyne.Window) func() {
+	return func() {
+		dialog.NewFileOpen(
+			func(f fyne.URIReadCloser, e error) {
+				if e != nil {
+					errorWindow(e, w)
+					return
+				}
+				if f == nil {
+					return
+				}
+				f.Close()
+				path := f.URI().Path()
+
+				name := widget.NewEntry()
+				name.SetText(filepath.Base(path))
+				dialog.NewForm(
+					"Send file",
+					"Send",
+					"Cancel",
+					[]*widget.FormItem{widget.NewFormItem("Share as", name)},
+					func(b bool) {
+						if !b {
+							return
+						}
+						if err := c.startTransfer(app, transfer{Name: name.Text, Path: path, Kind: transferSend}); err != nil {
+							errorWindow(err, w)
+							return
+						}
+						c.showTransfers(app)
+					}, w).Show()
+			}, w).Show()
+	}
+}
+
+func (c *vpn) receiveFile(app fyne.App, w fyne.Window) func() {
+	return func() {
+		name := widget.NewEntry()
+		dir := widget.NewEntry()
+		dir.SetText(c.ReceiveDir)
+		if dir.Text == "" {
+			if home, err := os.UserHomeDir(); err == nil {
+				dir.SetText(filepath.Join(home, "Downloads"))
+			}
+		}
+		browse := widget.NewButtonWithIcon("",
+			theme.FolderOpenIcon(),
+			func() {
+				dialog.NewFolderOpen(
+					func(u fyne.ListableURI, e error) {
+						if e != nil {
+							errorWindow(e, w)
+							return
+						}
+						if u != nil {
+							dir.SetText(u.Path())
+						}
+					}, w).Show()
+			})
+
+		dialog.NewForm(
+			"Receive file",
+			"Receive",
+			"Cancel",
+			[]*widget.FormItem{
+				widget.NewFormItem("Shared name", name),
+				widget.NewFormItem("Save to", container.NewBorder(nil, nil, nil, browse, dir)),
+			},
+			func(b bool) {
+				if !b {
+					return
+				}
+				os.MkdirAll(dir.Text, os.ModePerm)
+				t := transfer{
+					Name: name.Text,
+					Path: filepath.Join(dir.Text, filepath.Base(name.Text)),
+					Kind: transferReceive,
+				}
+				if err := c.startTransfer(app, t); err != nil {
+					errorWindow(err, w)
+					return
+				}
+				if c.ReceiveDir != dir.Text {
+					c.ReceiveDir = dir.Text
+					if err := c.writeJSON(); err != nil {
+						errorWindow(err, w)
+					}
+				}
+				c.showTransfers(app)
+			}, w).Show()
+	}
+}
+
+func (c *vpn) sendFileButton(app fyne.App, w fyne.Window) *widget.Button {
+	b := widget.NewButtonWithIcon("",
+		theme.UploadIcon(),
+		c.sendFile(app, w),
+	)
+	b.Importance = widget.LowImportance
+	return b
+}
+
+func (c *vpn) receiveFileButton(app fyne.App, w fyne.Window) *widget.Button {
+	b := widget.NewButtonWithIcon("",
+		theme.DownloadIcon(),
+		c.receiveFile(app, w),
+	)
+	b.Importance = widget.LowImportance
+	return b
+}
+
+func (c *vpn) transfersButton(app fyne.App) *widget.Button {
+	b := widget.NewButtonWithIcon("Files",
+		theme.FolderIcon(),
+		func() {
+			c.showTransfers(app)
+		},
+	)
+	b.Importance = widget.LowImportance
+	return b
+}
+
+// showTransfers shows the active file transfers of the connection
+func (c *vpn) showTransfers(app fyne.App) {
+	w := app.NewWindow(fmt.Sprintf("File transfers %s", c.Name))
+	list := container.NewVBox()
+
+	var refresh func()
+	refresh = func() {
+		objs := []fyne.CanvasObject{}
+		for _, t := range c.transfers() {
+			t := t
+			progress := widget.NewLabel(c.transferProgress(t))
+			progress.Wrapping = fyne.TextTruncate
+
+			stop := widget.NewButtonWithIcon("",
+				theme.CancelIcon(),
+				func() {
+					c.stopTransfer(t)
+					refresh()
+				})
+			stop.Importance = widget.LowImportance
+			logs := widget.NewButtonWithIcon("",
+				theme.FileTextIcon(),
+				c.logs(app, c.transferDir(t)),
+			)
+			logs.Importance = widget.LowImportance
+
+			objs = append(objs, container.NewBorder(
+				nil, nil, nil,
+				container.NewHBox(logs, stop),
+				container.NewVBox(
+					widget.NewLabelWithStyle(fmt.Sprintf("%s %s (%s)", t.Kind, t.Name, t.Path), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+					progress,
+				),
+			))
+		}
+		if len(objs) == 0 {
+			objs = append(objs, widget.NewLabel("No active file transfers"))
+		}
+		list.Objects = objs
+		list.Refresh()
+	}
+	refresh()
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				refresh()
+			case <-done:
+				return
+			}
+		}
+	}()
+	w.SetOnClosed(func() {
+		close(done)
+	})
+
+	w.SetContent(container.NewBorder(
+		container.NewHBox(
+			widget.NewButtonWithIcon("Send file", theme.UploadIcon(), c.sendFile(app, w)),
+			widget.NewButtonWithIcon("Receive file", theme.DownloadIcon(), c.receiveFile(app, w)),
+			layout.NewSpacer(),
+		),
+		nil,
+		nil,
+		nil,
+		container.NewVScroll(list),
+	))
+	w.Resize(fyne.NewSize(560, 320))
+	w.Show()
+}
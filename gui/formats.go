@@ -0,0 +1,317 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	deployBinary = "/usr/bin/edgevpn"
+	deployImage  = "quay.io/mudler/edgevpn"
+)
+
+// args returns the EdgeVPN arguments to run the connection. The token
+// is passed in the environment.
+func (c *vpn) args() []string {
+	if c.isProxy() {
+		return []string{"proxy", "--listen", c.ProxyAddress}
+	}
+	args := []string{"--address", c.IP, "--interface", c.Interface}
+	if c.API {
+		args = append(args, "--api", "--api-listen", c.APIAddress)
+	}
+	return append(args, c.dnsFlags()...)
+}
+
+// deployName returns a name for the connection usable in unit and resource names
+func (c *vpn) deployName() string {
+	return "edgevpn-" + strings.ToLower(strings.ReplaceAll(safeName(c.Name), "_", "-"))
+}
+
+// encodeYAML writes docs to w as YAML documents, indented as manifests usually are
+func encodeYAML(w io.Writer, docs ...interface{}) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	for _, d := range docs {
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	return enc.Close()
+}
+
+// exportFormat is a file the connection settings can be exported to
+type exportFormat struct {
+	Name      string
+	Extension string
+	render    func(c *vpn) ([]byte, error)
+}
+
+var exportFormats = []exportFormat{
+	{"Profile (JSON)", ".json", (*vpn).renderProfile},
+	{"EdgeVPN config (YAML)", ".yaml", (*vpn).renderConfig},
+	{"Env file", ".env", (*vpn).renderEnv},
+	{"systemd unit", ".service", (*vpn).renderSystemd},
+	{"docker-compose", ".docker-compose.yaml", (*vpn).renderCompose},
+	{"Kubernetes Secret and DaemonSet", ".k8s.yaml", (*vpn).renderKubernetes},
+}
+
+func (c *vpn) renderProfile() ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(c.stateDir, "data"))
+}
+
+// renderConfig returns the network configuration encoded in the token,
+// which EdgeVPN reads with --config.
+func (c *vpn) renderConfig() ([]byte, error) {
+	if _, err := decodeToken(c.Token); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(c.Token)
+}
+
+func (c *vpn) renderEnv() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# EdgeVPN connection %s\n", oneLine(c.Name))
+	fmt.Fprintf(&b, "EDGEVPNTOKEN=%s\n", c.Token)
+	if !c.isProxy() {
+		fmt.Fprintf(&b, "ADDRESS=%s\n", c.IP)
+		fmt.Fprintf(&b, "IFACE=%s\n", c.Interface)
+		fmt.Fprintf(&b, "API=%t\n", c.API)
+		if c.API {
+			fmt.Fprintf(&b, "APILISTEN=%s\n", c.APIAddress)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// systemdQuote quotes s as a single word of a systemd unit setting: specifiers
+// are escaped, and so are the quotes and backslashes in the double quoted string.
+func systemdQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// oneLine returns s on a single line, to be used in comments and unit descriptions
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func (c *vpn) renderSystemd() ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `# EdgeVPN connection %s
+# The unit contains the network token: keep it readable only by root.
+[Unit]
+Description=EdgeVPN %s
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Environment=%s
+ExecStart=%s %s
+Restart=always
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, oneLine(c.Name), oneLine(c.Name), systemdQuote("EDGEVPNTOKEN="+c.Token), deployBinary, strings.Join(c.args(), " "))
+	return b.Bytes(), nil
+}
+
+func (c *vpn) image() string {
+	if c.RuntimeVersion != "" && c.RuntimeVersion != customRuntime {
+		return fmt.Sprintf("%s:%s", deployImage, c.RuntimeVersion)
+	}
+	return deployImage + ":latest"
+}
+
+type composeService struct {
+	Image       string            `yaml:"image"`
+	NetworkMode string            `yaml:"network_mode"`
+	CapAdd      []string          `yaml:"cap_add,omitempty"`
+	Devices     []string          `yaml:"devices,omitempty"`
+	Environment map[string]string `yaml:"environment"`
+	Command     []string          `yaml:"command"`
+	Restart     string            `yaml:"restart"`
+}
+
+func (c *vpn) renderCompose() ([]byte, error) {
+	s := composeService{
+		Image:       c.image(),
+		NetworkMode: "host",
+		Environment: map[string]string{"EDGEVPNTOKEN": c.Token},
+		Command:     c.args(),
+		Restart:     "unless-stopped",
+	}
+	if !c.isProxy() {
+		s.CapAdd = []string{"NET_ADMIN"}
+		s.Devices = []string{"/dev/net/tun"}
+	}
+	var b bytes.Buffer
+	if err := encodeYAML(&b, map[string]map[string]composeService{
+		"services": {c.deployName(): s},
+	}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+type k8sMeta struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   k8sMeta           `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	StringData map[string]string `yaml:"stringData"`
+}
+
+type k8sEnv struct {
+	Name      string `yaml:"name"`
+	ValueFrom struct {
+		SecretKeyRef struct {
+			Name string `yaml:"name"`
+			Key  string `yaml:"key"`
+		} `yaml:"secretKeyRef"`
+	} `yaml:"valueFrom"`
+}
+
+type k8sContainer struct {
+	Name            string   `yaml:"name"`
+	Image           string   `yaml:"image"`
+	Args            []string `yaml:"args"`
+	Env             []k8sEnv `yaml:"env"`
+	SecurityContext struct {
+		Capabilities struct {
+			Add []string `yaml:"add,omitempty"`
+		} `yaml:"capabilities,omitempty"`
+		Privileged bool `yaml:"privileged,omitempty"`
+	} `yaml:"securityContext,omitempty"`
+}
+
+type k8sDaemonSet struct {
+	APIVersion string  `yaml:"apiVersion"`
+	Kind       string  `yaml:"kind"`
+	Metadata   k8sMeta `yaml:"metadata"`
+	Spec       struct {
+		Selector struct {
+			MatchLabels map[string]string `yaml:"matchLabels"`
+		} `yaml:"selector"`
+		Template struct {
+			Metadata k8sMeta `yaml:"metadata"`
+			Spec     struct {
+				HostNetwork bool           `yaml:"hostNetwork"`
+				Containers  []k8sContainer `yaml:"containers"`
+			} `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+func (c *vpn) renderKubernetes() ([]byte, error) {
+	name := c.deployName()
+	labels := map[string]string{"app": name}
+
+	secret := k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   k8sMeta{Name: name},
+		Type:       "Opaque",
+		StringData: map[string]string{"EDGEVPNTOKEN": c.Token},
+	}
+
+	ct := k8sContainer{Name: "edgevpn", Image: c.image(), Args: c.args()}
+	env := k8sEnv{Name: "EDGEVPNTOKEN"}
+	env.ValueFrom.SecretKeyRef.Name = name
+	env.ValueFrom.SecretKeyRef.Key = "EDGEVPNTOKEN"
+	ct.Env = []k8sEnv{env}
+	if !c.isProxy() {
+		ct.SecurityContext.Capabilities.Add = []string{"NET_ADMIN"}
+		ct.SecurityContext.Privileged = true
+	}
+
+	ds := k8sDaemonSet{APIVersion: "apps/v1", Kind: "DaemonSet", Metadata: k8sMeta{Name: name, Labels: labels}}
+	ds.Spec.Selector.MatchLabels = labels
+	ds.Spec.Template.Metadata = k8sMeta{Name: name, Labels: labels}
+	ds.Spec.Template.Spec.HostNetwork = true
+	ds.Spec.Template.Spec.Containers = []k8sContainer{ct}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# EdgeVPN connection %s\n", oneLine(c.Name))
+	if !c.isProxy() {
+		b.WriteString("# All the nodes of the DaemonSet use the same address: restrict it to a single node\n# with a nodeSelector, or use a different address per node.\n")
+	}
+	if err := encodeYAML(&b, secret, ds); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// exportAs asks for a format and a destination file to export the connection to
+func (c *vpn) exportAs(app fyne.App, w fyne.Window) {
+	names := []string{}
+	for _, f := range exportFormats {
+		names = append(names, f.Name)
+	}
+	format := widget.NewSelect(names, func(string) {})
+	format.SetSelected(names[0])
+
+	dialog.NewForm("Export", "Export", "Cancel",
+		[]*widget.FormItem{widget.NewFormItem("Format", format)},
+		func(b bool) {
+			if !b {
+				return
+			}
+			f := exportFormats[format.SelectedIndex()]
+			dat, err := f.render(c)
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+
+			d := dialog.NewFileSave(
+				func(out fyne.URIWriteCloser, e error) {
+					if e != nil {
+						errorWindow(e, w)
+						return
+					}
+					if out == nil {
+						return
+					}
+					defer out.Close()
+					if _, err := out.Write(dat); err != nil {
+						errorWindow(err, w)
+						return
+					}
+					app.SendNotification(fyne.NewNotification("info", "File saved"))
+				}, w)
+			d.SetFileName(safeName(c.Name) + f.Extension)
+			d.Show()
+		}, w).Show()
+}
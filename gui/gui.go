@@ -17,15 +17,17 @@ package gui
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
-	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	process "github.com/mudler/go-processmanager"
 	"github.com/nxadm/tail"
@@ -34,28 +36,87 @@ import (
 //go:generate fyne bundle -package gui -o data.go ../Icon.png
 
 func Run() {
+	flags := flag.NewFlagSet("edgevpn-gui", flag.ExitOnError)
+	state := flags.String("state-dir", "", fmt.Sprintf("Keep all the files in this directory (env %s)", stateDirEnv))
+	portable := flags.Bool("portable", false, fmt.Sprintf("Keep all the files next to the executable (env %s)", portableEnv))
+	start := flags.String("start", "", "Start the connection with this name")
+	flags.Parse(os.Args[1:])
+
+	if d, err := resolveDirs(*state, *portable); err != nil {
+		log.Println(err)
+	} else {
+		setDirs(d)
+	}
+
+	cmds := []instanceCommand{}
+	for _, a := range flags.Args() {
+		// Files are opened by the running instance, from another directory
+		if !strings.HasPrefix(a, invitePrefix) {
+			if abs, err := filepath.Abs(a); err == nil {
+				a = abs
+			}
+		}
+		cmds = append(cmds, instanceCommand{Action: commandOpen, Arg: a})
+	}
+	if *start != "" {
+		cmds = append(cmds, instanceCommand{Action: commandStart, Arg: *start})
+	}
 
-	app := app.New()
-	app.Settings().SetTheme(theme.LightTheme())
+	lock, err := lockInstance()
+	switch {
+	case err != nil:
+		log.Println("Running without the single instance lock:", err)
+	case lock == nil:
+		if len(cmds) == 0 {
+			cmds = []instanceCommand{{Action: commandShow}}
+		}
+		if err := forwardCommands(cmds); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	default:
+		defer lock.Close()
+	}
+
+	app := &notifier{App: app.New()}
+	go followDesktopTheme(app)
+	app.Settings().SetTheme(currentSettings().theme())
 	app.SetIcon(resourceIconPng)
+	downloads = newDownloadManager(app)
 
+	migrateProfiles()
 	c := newDashboard()
 	c.loadUI(app)
+	if currentDirs().fixed {
+		inviteHandlerArgs = []string{"--state-dir", stateDir()}
+	}
+	// The handler is registered again, in case the application moved
+	if currentSettings().InviteHandler {
+		if err := setInviteHandler(true); err != nil {
+			log.Println(err)
+		}
+	}
+	if lock != nil {
+		go c.serveInstance(app, lock)
+	}
+	for _, cmd := range cmds {
+		if err := c.runCommand(app, cmd); err != nil {
+			errorWindow(err, c.window)
+		}
+	}
 	makeTray(app, c)
+	newUpdateChecker(app, c).start(context.Background())
 	app.Run()
 }
 
 func errorWindow(err error, w fyne.Window) {
-	dialog.NewError(err, w).Show()
-}
-
-func stateDir() string {
-	dirname, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
+	if w == nil {
+		// Without a window the error is notified, if notifications are enabled
+		(&notifier{App: fyne.CurrentApp()}).SendNotification(fyne.NewNotification("error", err.Error()))
+		return
 	}
-
-	return filepath.Join(dirname, ".edgevpn")
+	dialog.NewError(err, w).Show()
 }
 
 func tailProcess(ctx context.Context, pr *process.Process, c chan string) {
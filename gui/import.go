@@ -0,0 +1,297 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	"gopkg.in/yaml.v3"
+)
+
+// checkToken returns an error if token is not an EdgeVPN network token
+func checkToken(token string) error {
+	t, err := decodeToken(token)
+	if err != nil {
+		return err
+	}
+	if t.Room == "" || t.Rendezvous == "" || t.OTP.DHT.Key == "" {
+		return fmt.Errorf("invalid token: not an EdgeVPN network configuration")
+	}
+	return nil
+}
+
+// parseProfile decodes a connection profile exported by the GUI
+func parseProfile(dat []byte) (*vpn, error) {
+	v := &vpn{}
+	if err := json.Unmarshal(dat, v); err != nil {
+		return nil, err
+	}
+	if v.Token == "" {
+		return nil, fmt.Errorf("no token found in the profile")
+	}
+	if err := checkToken(v.Token); err != nil {
+		return nil, err
+	}
+	// The binary and the directory receiving files are local to the machine exporting the profile
+	v.RuntimePath, v.ReceiveDir = "", ""
+	if v.RuntimeVersion == customRuntime {
+		v.RuntimeVersion = ""
+	}
+	if err := validateServices(v.Services); err != nil {
+		return nil, err
+	}
+	if err := v.validateDNS(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// parseNetworkConfig decodes a raw EdgeVPN network configuration,
+// the YAML encoded in tokens.
+func parseNetworkConfig(dat []byte) (*vpn, error) {
+	t := &tokenConfig{}
+	if err := yaml.Unmarshal(dat, t); err != nil {
+		return nil, err
+	}
+	if t.Room == "" || t.Rendezvous == "" || t.OTP.DHT.Key == "" {
+		return nil, fmt.Errorf("not an EdgeVPN network configuration")
+	}
+	return &vpn{Token: base64.StdEncoding.EncodeToString(dat)}, nil
+}
+
+// parseEnvFile decodes an env file with the variables read by EdgeVPN
+func parseEnvFile(dat []byte) (*vpn, error) {
+	env := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(dat))
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(l, "export "), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid line '%s'", l)
+		}
+		env[strings.TrimSpace(kv[0])] = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if env["EDGEVPNTOKEN"] == "" {
+		return nil, fmt.Errorf("no EDGEVPNTOKEN found in the env file")
+	}
+	if err := checkToken(env["EDGEVPNTOKEN"]); err != nil {
+		return nil, err
+	}
+
+	v := &vpn{
+		Token:      env["EDGEVPNTOKEN"],
+		IP:         env["ADDRESS"],
+		Interface:  env["IFACE"],
+		APIAddress: env["APILISTEN"],
+	}
+	v.API, _ = strconv.ParseBool(env["API"])
+	return v, nil
+}
+
+// parseImport decodes a connection from a GUI profile, a raw EdgeVPN
+// network configuration, an env file or a bare token.
+func parseImport(dat []byte) (*vpn, error) {
+	dat = bytes.TrimSpace(dat)
+	parsers := []struct {
+		format string
+		parse  func([]byte) (*vpn, error)
+	}{
+		{"profile", parseProfile},
+		{"network configuration", parseNetworkConfig},
+		{"env file", parseEnvFile},
+		{"token", func(dat []byte) (*vpn, error) {
+			if err := checkToken(string(dat)); err != nil {
+				return nil, err
+			}
+			return &vpn{Token: string(dat)}, nil
+		}},
+	}
+
+	errs := []string{}
+	for _, p := range parsers {
+		v, err := p.parse(dat)
+		if err == nil {
+			return v, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", p.format, err.Error()))
+	}
+	return nil, fmt.Errorf("unrecognized format:\n%s", strings.Join(errs, "\n"))
+}
+
+// conflicts returns the problems importing v next to the existing connections.
+// Name and interface conflicts are returned separately from IP collisions,
+// which are only warned about.
+func (v *vpn) conflicts(conns []*vpn) (nameTaken bool, problems, warnings []string) {
+	for _, c := range conns {
+		if c.Name == v.Name {
+			nameTaken = true
+			problems = append(problems, fmt.Sprintf("A connection named '%s' already exists", v.Name))
+			continue
+		}
+		if !v.isProxy() && !c.isProxy() && c.Interface == v.Interface {
+			problems = append(problems, fmt.Sprintf("The interface '%s' is used by '%s'", v.Interface, c.Name))
+		}
+	}
+	if owner := v.ipCollision(conns); owner != "" {
+		warnings = append(warnings, fmt.Sprintf("%s is already used by '%s'", v.IP, owner))
+	}
+	return
+}
+
+// uniqueName returns name, with a numeric suffix if a connection with it exists
+func uniqueName(name string, conns []*vpn) string {
+	taken := map[string]bool{}
+	for _, c := range conns {
+		taken[c.Name] = true
+	}
+	n := name
+	for i := 2; taken[n]; i++ {
+		n = fmt.Sprintf("%s-%d", name, i)
+	}
+	return n
+}
+
+// freeInterface returns iface, or the first edgevpnN interface not used by conns
+func freeInterface(iface string, conns []*vpn) string {
+	used := map[string]bool{}
+	for _, c := range conns {
+		if !c.isProxy() {
+			used[c.Interface] = true
+		}
+	}
+	if iface != "" && !used[iface] {
+		return iface
+	}
+	n := ""
+	for i := 0; ; i++ {
+		n = fmt.Sprintf("edgevpn%d", i)
+		if !used[n] {
+			return n
+		}
+	}
+}
+
+// importProfile imports data read from file, asking how to resolve
+// conflicts with existing connections. Incomplete profiles are opened
+// in the new connection form.
+func (c *dashboard) importProfile(app fyne.App, dat []byte, file string) {
+	v, err := parseImport(dat)
+	if err != nil {
+		errorWindow(err, c.window)
+		return
+	}
+	// Imported profiles are new, unless replacing an existing one
+	v.ID = ""
+	v.parent = c
+	if v.Name == "" {
+		v.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	if v.Interface == "" && !v.isProxy() {
+		v.Interface = currentSettings().DefaultInterface
+	}
+
+	if err := v.validate(); err != nil {
+		app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Complete the imported settings: %s", err.Error())))
+		v.generateUI(app, false)
+		return
+	}
+
+	write := func(v *vpn) {
+		if err := v.writeJSON(); err != nil {
+			errorWindow(err, c.window)
+			return
+		}
+		c.Reload(app)
+		app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("'%s' imported", v.Name)))
+	}
+
+	conns := c.connections()
+	nameTaken, problems, warnings := v.conflicts(conns)
+	if len(problems)+len(warnings) == 0 {
+		write(v)
+		return
+	}
+
+	name := widget.NewEntry()
+	name.SetText(uniqueName(v.Name, conns))
+	iface := widget.NewEntry()
+	iface.SetText(freeInterface(v.Interface, conns))
+
+	var d dialog.Dialog
+	rename := widget.NewButton("Rename", func() {
+		d.Hide()
+		r := *v
+		r.Name = name.Text
+		r.Interface = iface.Text
+		if _, problems, _ := r.conflicts(conns); len(problems) > 0 {
+			errorWindow(errors.New(strings.Join(problems, "\n")), c.window)
+			return
+		}
+		write(&r)
+	})
+	replace := widget.NewButton("Replace", func() {
+		d.Hide()
+		others := []*vpn{}
+		for _, e := range conns {
+			if e.Name == v.Name {
+				v.ID = e.ID
+				continue
+			}
+			others = append(others, e)
+		}
+		// Only IP collisions are kept: interfaces can't be shared by running connections
+		if !v.isProxy() {
+			if iface := freeInterface(v.Interface, others); iface != v.Interface {
+				app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("'%s' uses the interface '%s', as '%s' is taken", v.Name, iface, v.Interface)))
+				v.Interface = iface
+			}
+		}
+		write(v)
+	})
+	if !nameTaken {
+		replace.SetText("Import anyway")
+	}
+	rename.Importance = widget.HighImportance
+
+	d = dialog.NewCustom("Import conflicts", "Skip",
+		container.NewVBox(
+			widget.NewLabel(strings.Join(append(problems, warnings...), "\n")),
+			widget.NewForm(
+				widget.NewFormItem("Name", name),
+				widget.NewFormItem("Interface", iface),
+			),
+			container.NewHBox(rename, replace),
+		), c.window)
+	d.Show()
+}
@@ -0,0 +1,168 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fyne.io/fyne/v2"
+)
+
+// instanceSocket is the socket the running instance listens on, in the state
+// directory: instances with different state directories are independent.
+const instanceSocket = "gui.sock"
+
+const (
+	commandShow  = "show"
+	commandOpen  = "open"
+	commandStart = "start"
+)
+
+// instanceCommand is forwarded by a second launch to the running instance
+type instanceCommand struct {
+	Action string `json:"action"`
+	Arg    string `json:"arg,omitempty"`
+}
+
+type instanceReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+func instanceSocketPath() string {
+	return filepath.Join(stateDir(), instanceSocket)
+}
+
+// lockInstance listens on the instance socket. It returns nil, and no error,
+// if another instance is already running.
+func lockInstance() (net.Listener, error) {
+	path := instanceSocketPath()
+	if err := os.MkdirAll(stateDir(), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		var l net.Listener
+		l, err = net.Listen("unix", path)
+		if err == nil {
+			os.Chmod(path, 0600)
+			return l, nil
+		}
+		if conn, derr := net.DialTimeout("unix", path, time.Second); derr == nil {
+			conn.Close()
+			return nil, nil
+		}
+		// Left by an instance which didn't exit cleanly
+		if rerr := os.Remove(path); rerr != nil && !os.IsNotExist(rerr) {
+			return nil, rerr
+		}
+	}
+	return nil, fmt.Errorf("failed listening on %s: %w", path, err)
+}
+
+// forwardCommands sends cmds to the running instance
+func forwardCommands(cmds []instanceCommand) error {
+	conn, err := net.DialTimeout("unix", instanceSocketPath(), time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	for _, cmd := range cmds {
+		if err := enc.Encode(cmd); err != nil {
+			return err
+		}
+		var r instanceReply
+		if err := dec.Decode(&r); err != nil {
+			return err
+		}
+		if r.Error != "" {
+			return errors.New(r.Error)
+		}
+	}
+	return nil
+}
+
+// serveInstance runs the commands forwarded by other launches until l is closed
+func (c *dashboard) serveInstance(app fyne.App, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			dec := json.NewDecoder(conn)
+			enc := json.NewEncoder(conn)
+			for {
+				var cmd instanceCommand
+				if err := dec.Decode(&cmd); err != nil {
+					if err != io.EOF {
+						log.Println(err)
+					}
+					return
+				}
+				r := instanceReply{}
+				if err := c.runCommand(app, cmd); err != nil {
+					r.Error = err.Error()
+				}
+				if err := enc.Encode(r); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// runCommand runs a command given on the command line, or forwarded by another launch
+func (c *dashboard) runCommand(app fyne.App, cmd instanceCommand) error {
+	switch cmd.Action {
+	case commandShow:
+		c.show(app)
+		c.window.RequestFocus()
+	case commandOpen:
+		c.show(app)
+		c.openArg(app, cmd.Arg)
+	case commandStart:
+		for _, v := range c.connections() {
+			if v.Name != cmd.Arg && v.ID != cmd.Arg {
+				continue
+			}
+			if v.isAlive() {
+				return fmt.Errorf("'%s' is already running", v.Name)
+			}
+			v.start(app, nil)()
+			return nil
+		}
+		return fmt.Errorf("no connection named '%s'", cmd.Arg)
+	default:
+		return fmt.Errorf("unknown command '%s'", cmd.Action)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/go-vgo/robotgo/clipboard"
+)
+
+const (
+	invitePrefix    = "edgevpn://invite/"
+	inviteExtension = ".edgevpn-invite"
+	inviteDesktop   = "edgevpn-gui-invite.desktop"
+)
+
+var inviteExpiries = map[string]time.Duration{
+	"1 hour":   time.Hour,
+	"24 hours": 24 * time.Hour,
+	"7 days":   7 * 24 * time.Hour,
+}
+
+// invite carries a connection profile for a new peer, with the IP
+// it should use, and is refused on import after it expires.
+type invite struct {
+	Profile vpn       `json:"profile"`
+	IP      string    `json:"ip"`
+	Expires time.Time `json:"expires"`
+}
+
+func (c *vpn) newInvite(ip string, ttl time.Duration) invite {
+	return invite{
+		Profile: c.shared(),
+		IP:      ip,
+		Expires: time.Now().Add(ttl).UTC(),
+	}
+}
+
+// link returns the edgevpn:// link of the invite
+func (i invite) link() (string, error) {
+	dat, err := json.Marshal(i)
+	if err != nil {
+		return "", err
+	}
+	return invitePrefix + base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+// parseInvite decodes an invite link, and fails if it is expired
+func parseInvite(link string) (*invite, error) {
+	link = strings.TrimSpace(link)
+	if !strings.HasPrefix(link, invitePrefix) {
+		return nil, fmt.Errorf("not an invite link: it must start with %s", invitePrefix)
+	}
+	dat, err := base64.RawURLEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(link, invitePrefix), "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid invite link: %w", err)
+	}
+	i := &invite{}
+	if err := json.Unmarshal(dat, i); err != nil {
+		return nil, fmt.Errorf("invalid invite link: %w", err)
+	}
+	if i.Profile.Token == "" {
+		return nil, fmt.Errorf("invalid invite link: no token found")
+	}
+	if err := checkToken(i.Profile.Token); err != nil {
+		return nil, fmt.Errorf("invalid invite link: %w", err)
+	}
+	if time.Now().After(i.Expires) {
+		return nil, fmt.Errorf("the invite to '%s' expired on %s, ask for a new one", i.Profile.Name, i.Expires.Local().Format(time.RFC1123))
+	}
+	return i, nil
+}
+
+func (c *vpn) showInvite(app fyne.App, conns []*vpn) {
+	w := app.NewWindow(fmt.Sprintf("Invite to %s", c.Name))
+
+	ip := widget.NewEntry()
+	if c.isProxy() {
+		ip.Disable()
+	} else if s, err := c.suggestIP(conns); err == nil {
+		ip.SetText(s)
+	}
+
+	expiry := widget.NewSelect([]string{"1 hour", "24 hours", "7 days"}, func(string) {})
+	expiry.SetSelected("24 hours")
+
+	link := widget.NewMultiLineEntry()
+	link.Wrapping = fyne.TextWrapBreak
+	link.Disable()
+
+	generate := func() (string, error) {
+		l, err := c.newInvite(ip.Text, inviteExpiries[expiry.Selected]).link()
+		if err != nil {
+			return "", err
+		}
+		link.SetText(l)
+		return l, nil
+	}
+
+	copyLink := widget.NewButtonWithIcon("Copy link",
+		theme.ContentCopyIcon(),
+		func() {
+			l, err := generate()
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			clipboard.WriteAll(l)
+			app.SendNotification(fyne.NewNotification("info", "Invite link copied to clipboard"))
+		})
+	save := widget.NewButtonWithIcon("Save file",
+		theme.DocumentSaveIcon(),
+		func() {
+			l, err := generate()
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			d := dialog.NewFileSave(
+				func(f fyne.URIWriteCloser, e error) {
+					if e != nil {
+						errorWindow(e, w)
+						return
+					}
+					if f == nil {
+						return
+					}
+					defer f.Close()
+					if _, err := f.Write([]byte(l + "\n")); err != nil {
+						errorWindow(err, w)
+						return
+					}
+					app.SendNotification(fyne.NewNotification("info", "Invite saved"))
+				}, w)
+			d.SetFileName(safeName(c.Name) + inviteExtension)
+			d.Show()
+		})
+
+	warning := widget.NewLabel("The invite contains the network token. Expired invites are refused on import, but the token stays valid until it is rotated.")
+	warning.Wrapping = fyne.TextWrapWord
+
+	w.SetContent(container.NewVBox(
+		widget.NewForm(
+			widget.NewFormItem("Suggested IP", ip),
+			widget.NewFormItem("Expires in", expiry),
+		),
+		warning,
+		container.NewGridWrap(fyne.NewSize(400, 100), link),
+		container.NewHBox(copyLink, save),
+	))
+	w.Show()
+}
+
+// openInvite opens the new connection form with the profile of an invite link
+func (c *dashboard) openInvite(app fyne.App, link string) {
+	i, err := parseInvite(link)
+	if err != nil {
+		errorWindow(err, c.window)
+		return
+	}
+	// Only the settings shown in the form are taken from the invite
+	v := &vpn{
+		Name:         i.Profile.Name,
+		Token:        i.Profile.Token,
+		IP:           i.Profile.IP,
+		IPPool:       i.Profile.IPPool,
+		Mode:         i.Profile.Mode,
+		ProxyAddress: i.Profile.ProxyAddress,
+		Interface:    currentSettings().DefaultInterface,
+		parent:       c,
+	}
+	if i.IP != "" {
+		v.IP = i.IP
+	}
+	if err := v.validate(); err != nil {
+		errorWindow(fmt.Errorf("invalid invite link: %w", err), c.window)
+		return
+	}
+	v.generateUI(app, false)
+}
+
+// openArg handles an argument given on the command line: an invite link,
+// or a file containing an invite, a bundle or a profile to import.
+func (c *dashboard) openArg(app fyne.App, arg string) {
+	if strings.HasPrefix(arg, invitePrefix) {
+		c.openInvite(app, arg)
+		return
+	}
+
+	dat, err := ioutil.ReadFile(arg)
+	if err != nil {
+		errorWindow(err, c.window)
+		return
+	}
+	switch {
+	case strings.HasSuffix(arg, inviteExtension):
+		c.openInvite(app, string(dat))
+	case bytes.HasPrefix(dat, []byte(bundleMagic)):
+		c.openBundle(app, dat)
+	default:
+		c.importProfile(app, dat, filepath.Base(arg))
+	}
+}
+
+// importInvite asks for an invite link, or a file containing one
+func (c *dashboard) importInvite(app fyne.App) {
+	link := widget.NewMultiLineEntry()
+	link.SetPlaceHolder(invitePrefix + "...")
+	link.Wrapping = fyne.TextWrapBreak
+
+	open := widget.NewButtonWithIcon("Open file",
+		theme.FolderOpenIcon(),
+		func() {
+			dialog.NewFileOpen(
+				func(f fyne.URIReadCloser, e error) {
+					if e != nil {
+						errorWindow(e, c.window)
+						return
+					}
+					if f == nil {
+						return
+					}
+					defer f.Close()
+					dat, err := ioutil.ReadAll(f)
+					if err != nil {
+						errorWindow(err, c.window)
+						return
+					}
+					link.SetText(string(bytes.TrimSpace(dat)))
+				}, c.window).Show()
+		})
+
+	dialog.NewCustomConfirm("Import invite", "Import", "Cancel",
+		container.NewBorder(nil, open, nil, nil, container.NewGridWrap(fyne.NewSize(400, 120), link)),
+		func(b bool) {
+			if b {
+				c.openInvite(app, link.Text)
+			}
+		}, c.window).Show()
+}
+
+// inviteHandlerArgs are passed to the GUI before the link by the invite links handler
+var inviteHandlerArgs []string
+
+// applicationsDir returns the directory of the desktop entries of the user
+func applicationsDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "applications"), nil
+}
+
+// setInviteHandler registers or unregisters the GUI as the handler of invite links.
+// Portable installs don't change the system they run on, and are never registered.
+func setInviteHandler(enabled bool) error {
+	if currentDirs().portable {
+		return nil
+	}
+	if enabled {
+		return registerInviteHandler(inviteHandlerArgs...)
+	}
+	return unregisterInviteHandler()
+}
+
+// registerInviteHandler registers the GUI as the handler of invite links
+// for the current user on desktops following the XDG specifications.
+// args are passed to the GUI before the link.
+func registerInviteHandler(args ...string) error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	dir, err := applicationsDir()
+	if err != nil {
+		return err
+	}
+
+	entry := fmt.Sprintf(`[Desktop Entry]
+Type=Application
+Name=EdgeVPN invite
+Exec=%s %%u
+Icon=edgevpn
+NoDisplay=true
+MimeType=x-scheme-handler/edgevpn;
+`, strings.Join(quoteExec(append([]string{exe}, args...)), " "))
+
+	path := filepath.Join(dir, inviteDesktop)
+	if dat, err := ioutil.ReadFile(path); err == nil && string(dat) == entry {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return err
+	}
+	if out, err := exec.Command("xdg-mime", "default", inviteDesktop, "x-scheme-handler/edgevpn").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed registering the invite handler: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// unregisterInviteHandler removes the desktop entry handling invite links
+func unregisterInviteHandler() error {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	dir, err := applicationsDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, inviteDesktop)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// quoteExec quotes the arguments of a desktop entry Exec key
+func quoteExec(args []string) []string {
+	quoted := make([]string, len(args))
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`", "$", `\$`)
+	for i, a := range args {
+		quoted[i] = `"` + r.Replace(a) + `"`
+	}
+	return quoted
+}
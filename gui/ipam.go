@@ -0,0 +1,190 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const defaultIPPool = "10.1.0.0/24"
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// freeIP returns the first host address of network not in used, in CIDR notation
+func freeIP(network *net.IPNet, used map[string]bool) (string, error) {
+	ones, bits := network.Mask.Size()
+	ip := network.IP.Mask(network.Mask)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^network.Mask[len(network.Mask)-len(ip)+i]
+	}
+
+	for ip = nextIP(ip); network.Contains(ip); ip = nextIP(ip) {
+		if bits-ones > 1 && ip.Equal(broadcast) {
+			break
+		}
+		if !used[ip.String()] {
+			return fmt.Sprintf("%s/%d", ip.String(), ones), nil
+		}
+	}
+	return "", fmt.Errorf("no free addresses left in %s", network.String())
+}
+
+// pool returns the network free addresses are allocated from: the configured
+// pool, or the network of the connection IP.
+func (c *vpn) pool() (*net.IPNet, error) {
+	p := c.IPPool
+	if p == "" {
+		if _, n, err := net.ParseCIDR(c.IP); err == nil {
+			return n, nil
+		}
+		p = currentSettings().DefaultIPPool
+	}
+	_, n, err := net.ParseCIDR(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address pool '%s': %w", p, err)
+	}
+	return n, nil
+}
+
+// usedIPs returns the addresses known to be allocated in the network of the
+// connection, with the name of the node using them: the ones of the profiles in
+// conns on the same network and, if one of them has the API available, the ones
+// announced by the other nodes.
+func (c *vpn) usedIPs(conns []*vpn) map[string]string {
+	used := map[string]string{}
+	add := func(a, owner string) {
+		if ip, _, err := net.ParseCIDR(a); err == nil {
+			used[ip.String()] = owner
+		} else if ip := net.ParseIP(strings.TrimSpace(a)); ip != nil {
+			used[ip.String()] = owner
+		}
+	}
+
+	var api *apiClient
+	for _, v := range conns {
+		if v.Token != c.Token || v.isProxy() {
+			continue
+		}
+		add(v.IP, v.Name)
+		if api == nil {
+			api, _ = v.api()
+		}
+	}
+	if api != nil {
+		if machines, err := api.machines(); err == nil {
+			for _, m := range machines {
+				add(m.Address, m.Hostname)
+			}
+		}
+	}
+	return used
+}
+
+// suggestIP returns a free address in the pool of the connection
+func (c *vpn) suggestIP(conns []*vpn) (string, error) {
+	network, err := c.pool()
+	if err != nil {
+		return "", err
+	}
+	used := c.usedIPs(conns)
+	taken := map[string]bool{}
+	for ip := range used {
+		taken[ip] = true
+	}
+	return freeIP(network, taken)
+}
+
+// ipCollision returns the node already using the IP of the connection, or
+// an empty string. This host and the profile itself are not considered.
+func (c *vpn) ipCollision(conns []*vpn) string {
+	ip, _, err := net.ParseCIDR(c.IP)
+	if err != nil || c.isProxy() {
+		return ""
+	}
+
+	others := []*vpn{}
+	for _, v := range conns {
+		if v.Name == c.Name || (c.ID != "" && v.ID == c.ID) {
+			continue
+		}
+		others = append(others, v)
+	}
+
+	hostname, _ := os.Hostname()
+	if owner, ok := c.usedIPs(others)[ip.String()]; ok && owner != hostname {
+		return owner
+	}
+	return ""
+}
+
+// ipForm returns the form items to set the IP of the connection and the pool
+// addresses are assigned from. token returns the token of the network being
+// edited, and the returned function the selected IP and pool.
+func (c *vpn) ipForm(w fyne.Window, token func() string) ([]*widget.FormItem, func() (string, string)) {
+	ip := widget.NewEntry()
+	ip.SetText(c.IP)
+	ip.SetPlaceHolder("e.g. 10.1.0.5/24")
+	pool := widget.NewEntry()
+	pool.SetText(c.IPPool)
+	pool.SetPlaceHolder(currentSettings().DefaultIPPool)
+
+	auto := widget.NewButtonWithIcon("Auto-assign",
+		theme.SearchIcon(),
+		func() {
+			d := vpn{Name: c.Name, Token: token(), IPPool: pool.Text}
+			if d.IPPool == "" {
+				d.IP = ip.Text
+			}
+			s, err := d.suggestIP(loadConnections(c.parent))
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			ip.SetText(s)
+		})
+
+	selectedIP := func() (string, string) {
+		return ip.Text, pool.Text
+	}
+
+	return []*widget.FormItem{
+		widget.NewFormItem("IP", container.NewBorder(nil, nil, nil, auto, ip)),
+		widget.NewFormItem("Address pool", pool),
+	}, selectedIP
+}
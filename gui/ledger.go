@@ -0,0 +1,276 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const ledgerRefreshInterval = 5 * time.Second
+
+// prettyJSON indents a ledger value. Values stored as JSON encoded
+// strings are decoded first.
+func prettyJSON(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if !json.Valid([]byte(s)) {
+			return s
+		}
+		raw = json.RawMessage(s)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, raw, "", "  "); err != nil {
+		return string(raw)
+	}
+	return out.String()
+}
+
+func sortedKeys(m map[string]json.RawMessage) (keys []string) {
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+type ledgerSnapshot struct {
+	Block  block                                 `json:"block"`
+	Ledger map[string]map[string]json.RawMessage `json:"ledger"`
+}
+
+// ledgerBrowser is a read-only view over the shared ledger of a connection
+type ledgerBrowser struct {
+	sync.Mutex
+	c        *vpn
+	snapshot ledgerSnapshot
+
+	buckets  []string
+	keys     []string
+	bucket   string
+	key      string
+	status   *widget.Label
+	value    *widget.Label
+	bucketsW *widget.List
+	keysW    *widget.List
+}
+
+func (l *ledgerBrowser) refresh() {
+	api, err := l.c.api()
+	if err != nil {
+		l.status.SetText(err.Error())
+		return
+	}
+	ledger, err := api.ledger()
+	if err != nil {
+		l.status.SetText(fmt.Sprintf("Failed retrieving the ledger: %s", err.Error()))
+		return
+	}
+	b, err := api.lastBlock()
+	if err != nil {
+		l.status.SetText(fmt.Sprintf("Failed retrieving the last block: %s", err.Error()))
+		return
+	}
+
+	l.Lock()
+	l.snapshot = ledgerSnapshot{Block: b, Ledger: ledger}
+	l.buckets = []string{}
+	for k := range ledger {
+		l.buckets = append(l.buckets, k)
+	}
+	sort.Strings(l.buckets)
+	l.keys = sortedKeys(ledger[l.bucket])
+	l.Unlock()
+
+	l.status.SetText(fmt.Sprintf("Block height %d, last block at %s", b.Index, b.Timestamp))
+	l.bucketsW.Refresh()
+	l.keysW.Refresh()
+	l.showValue()
+}
+
+func (l *ledgerBrowser) showValue() {
+	l.Lock()
+	raw, ok := l.snapshot.Ledger[l.bucket][l.key]
+	l.Unlock()
+	if !ok {
+		l.value.SetText("")
+		return
+	}
+	l.value.SetText(prettyJSON(raw))
+}
+
+func (l *ledgerBrowser) export(w fyne.Window, app fyne.App) {
+	dialog.NewFileSave(
+		func(f fyne.URIWriteCloser, e error) {
+			if e != nil {
+				errorWindow(e, w)
+				return
+			}
+			if f == nil {
+				return
+			}
+			defer f.Close()
+
+			l.Lock()
+			dat, err := json.MarshalIndent(l.snapshot, "", "  ")
+			l.Unlock()
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			if _, err := f.Write(dat); err != nil {
+				errorWindow(err, w)
+				return
+			}
+			app.SendNotification(fyne.NewNotification("info", "Ledger snapshot saved"))
+		}, w).Show()
+}
+
+func (c *vpn) showLedger(app fyne.App) {
+	w := app.NewWindow(fmt.Sprintf("Ledger %s", c.Name))
+
+	l := &ledgerBrowser{
+		c:      c,
+		status: widget.NewLabel(""),
+		value:  widget.NewLabelWithStyle("", fyne.TextAlignLeading, fyne.TextStyle{Monospace: true}),
+	}
+
+	l.bucketsW = widget.NewList(
+		func() int {
+			l.Lock()
+			defer l.Unlock()
+			return len(l.buckets)
+		},
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			l.Lock()
+			defer l.Unlock()
+			if i < len(l.buckets) {
+				o.(*widget.Label).SetText(l.buckets[i])
+			}
+		},
+	)
+	l.keysW = widget.NewList(
+		func() int {
+			l.Lock()
+			defer l.Unlock()
+			return len(l.keys)
+		},
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			l.Lock()
+			defer l.Unlock()
+			if i < len(l.keys) {
+				o.(*widget.Label).SetText(l.keys[i])
+			}
+		},
+	)
+	l.bucketsW.OnSelected = func(i widget.ListItemID) {
+		l.Lock()
+		// The list can shrink with a refresh after the selection was rendered
+		if i >= len(l.buckets) {
+			l.Unlock()
+			return
+		}
+		l.bucket = l.buckets[i]
+		l.keys = sortedKeys(l.snapshot.Ledger[l.bucket])
+		l.key = ""
+		l.Unlock()
+		l.keysW.UnselectAll()
+		l.keysW.Refresh()
+		l.showValue()
+	}
+	l.keysW.OnSelected = func(i widget.ListItemID) {
+		l.Lock()
+		if i >= len(l.keys) {
+			l.Unlock()
+			return
+		}
+		l.key = l.keys[i]
+		l.Unlock()
+		l.showValue()
+	}
+
+	done := make(chan struct{})
+	live := widget.NewCheck("Live refresh", func(bool) {})
+	go func() {
+		t := time.NewTicker(ledgerRefreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if live.Checked {
+					l.refresh()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	w.SetOnClosed(func() {
+		close(done)
+	})
+
+	lists := container.NewHSplit(
+		container.NewBorder(widget.NewLabelWithStyle("Buckets", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}), nil, nil, nil, l.bucketsW),
+		container.NewBorder(widget.NewLabelWithStyle("Keys", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}), nil, nil, nil, l.keysW),
+	)
+	content := container.NewHSplit(lists, container.NewScroll(l.value))
+	content.SetOffset(0.4)
+
+	w.SetContent(container.NewBorder(
+		container.NewHBox(
+			l.status,
+			layout.NewSpacer(),
+			live,
+			widget.NewButtonWithIcon("", theme.ViewRefreshIcon(), l.refresh),
+			widget.NewButtonWithIcon("Export", theme.UploadIcon(), func() {
+				l.export(w, app)
+			}),
+		),
+		nil,
+		nil,
+		nil,
+		content,
+	))
+	w.Resize(fyne.NewSize(800, 480))
+	w.Show()
+
+	l.refresh()
+}
+
+func (c *vpn) ledgerButton(app fyne.App) *widget.Button {
+	b := widget.NewButtonWithIcon("Ledger",
+		theme.ListIcon(),
+		func() {
+			c.showLedger(app)
+		},
+	)
+	b.Importance = widget.LowImportance
+	return b
+}
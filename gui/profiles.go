@@ -0,0 +1,112 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Connection profiles are stored in a directory of the state directory named
+// after their ID, which never changes. Names are only displayed, so profiles
+// can be renamed without moving their state.
+
+// newProfileID returns a random identifier for a new connection profile
+func newProfileID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// reservedIDs are directories of the state directory not used by profiles
+var reservedIDs = map[string]bool{"bin": true, "downloads": true}
+
+// validID checks that id can be used as a directory of the state directory.
+// Profiles migrated from older versions can have any name valid as a directory.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && !reservedIDs[id] && !strings.ContainsAny(id, `/\`)
+}
+
+func profileDir(id string) string {
+	return filepath.Join(stateDir(), id)
+}
+
+// checkName returns an error if another profile than the one with id is named name
+func checkName(id, name string) error {
+	for _, v := range loadConnections(nil) {
+		if v.ID != id && v.Name == name {
+			return fmt.Errorf("a connection named '%s' already exists", name)
+		}
+	}
+	return nil
+}
+
+// migrateProfiles moves the profiles stored in directories named after the
+// connection, as done by older versions, to directories named after a new ID.
+// It runs before any profile is loaded: running connections keep their
+// directory name as ID until they are stopped and the GUI restarted.
+func migrateProfiles() {
+	files, _ := ioutil.ReadDir(stateDir())
+	for _, f := range files {
+		if f.IsDir() && !reservedIDs[f.Name()] {
+			migrateProfile(filepath.Join(stateDir(), f.Name()))
+		}
+	}
+}
+
+// migrateProfile moves a legacy profile to a directory named after a new ID,
+// and returns the directory of the profile.
+func migrateProfile(dir string) string {
+	dat, err := ioutil.ReadFile(filepath.Join(dir, "data"))
+	if err != nil {
+		return dir
+	}
+	profile := map[string]interface{}{}
+	if err := json.Unmarshal(dat, &profile); err != nil {
+		return dir
+	}
+	if _, ok := profile["id"]; ok {
+		return dir
+	}
+	if newVPN(dir, nil).isAlive() {
+		return dir
+	}
+
+	id, err := newProfileID()
+	if err != nil {
+		return dir
+	}
+	profile["id"] = id
+	if dat, err = json.Marshal(profile); err != nil {
+		return dir
+	}
+	// The ID is written first: if moving fails the directory name is kept as ID
+	if err := os.WriteFile(filepath.Join(dir, "data"), dat, os.ModePerm); err != nil {
+		return dir
+	}
+	if err := os.Rename(dir, profileDir(id)); err != nil {
+		return dir
+	}
+	return profileDir(id)
+}
@@ -0,0 +1,122 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"net"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/go-vgo/robotgo/clipboard"
+)
+
+const (
+	// modeVPN creates a TUN interface and requires root permissions
+	modeVPN = "vpn"
+	// modeProxy only starts a local proxy to the network, and runs unprivileged
+	modeProxy = "proxy"
+
+	defaultProxyAddress = "127.0.0.1:8081"
+)
+
+var modeLabels = map[string]string{
+	modeVPN:   "Full VPN",
+	modeProxy: "Proxy only",
+}
+
+func (c *vpn) isProxy() bool {
+	return c.Mode == modeProxy
+}
+
+func (c *vpn) validateProxy() error {
+	return validateAddress("proxy", c.ProxyAddress)
+}
+
+// proxyURL returns the address clients can use to connect to the proxy
+func (c *vpn) proxyURL() string {
+	host, port, err := net.SplitHostPort(c.ProxyAddress)
+	if err != nil {
+		return c.ProxyAddress
+	}
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
+}
+
+func (c *vpn) proxyClipboardButton(app fyne.App) *widget.Button {
+	b := widget.NewButtonWithIcon("",
+		theme.ContentCopyIcon(),
+		func() {
+			clipboard.WriteAll(c.proxyURL())
+			app.SendNotification(fyne.NewNotification("info", "Proxy address copied to clipboard"))
+		},
+	)
+	b.Importance = widget.LowImportance
+	return b
+}
+
+// modeForm returns the form items to select the connection mode. vpnItems are
+// hidden when the proxy mode is selected, and onChange is called after the mode
+// changed. The returned function returns the selected mode and proxy address.
+func (c *vpn) modeForm(onChange func(proxy bool), vpnItems ...*widget.FormItem) ([]*widget.FormItem, func() (string, string)) {
+	proxyAddress := widget.NewEntry()
+	proxyAddress.SetText(c.ProxyAddress)
+	if proxyAddress.Text == "" {
+		proxyAddress.SetText(defaultProxyAddress)
+	}
+	proxyForm := widget.NewFormItem("Proxy Listen Address", proxyAddress)
+
+	mode := widget.NewRadioGroup([]string{modeLabels[modeVPN], modeLabels[modeProxy]}, func(s string) {
+		proxy := s == modeLabels[modeProxy]
+		if proxy {
+			proxyForm.Widget.Show()
+			for _, i := range vpnItems {
+				i.Widget.Hide()
+			}
+		} else {
+			proxyForm.Widget.Hide()
+			for _, i := range vpnItems {
+				i.Widget.Show()
+			}
+		}
+		if onChange != nil {
+			onChange(proxy)
+		}
+	})
+	mode.Horizontal = true
+	mode.Required = true
+	if c.isProxy() {
+		mode.SetSelected(modeLabels[modeProxy])
+	} else {
+		mode.SetSelected(modeLabels[modeVPN])
+	}
+
+	selectedMode := func() (string, string) {
+		if mode.Selected == modeLabels[modeProxy] {
+			return modeProxy, proxyAddress.Text
+		}
+		return modeVPN, c.ProxyAddress
+	}
+
+	return []*widget.FormItem{
+		widget.NewFormItem("Mode", mode),
+		proxyForm,
+	}, selectedMode
+}
@@ -3,27 +3,48 @@ package gui
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
 )
 
 func isInstalled(pr string) bool {
-	paths := strings.Split(os.Getenv("PATH"), ":")
+	_, err := exec.LookPath(pr)
+	return err == nil
+}
 
-	for _, p := range paths {
-		path := filepath.Join(p, pr)
-		_, err := os.Lstat(path)
-		if err == nil {
-			return true
-		}
+// isExecutable returns true if path is a regular file that can be executed
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// probeVersion returns the version reported by an EdgeVPN binary
+func probeVersion(bin string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, bin, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed probing version of '%s': %w", bin, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no version reported by '%s'", bin)
 	}
-	return false
+	return fields[len(fields)-1], nil
 }
 
 type releaseFinder struct {
@@ -42,36 +63,61 @@ func newReleaseFinder(ctx context.Context, token string) *releaseFinder {
 }
 
 func binaryVersion(v string) string {
-	return filepath.Join(stateDir(), "bin", fmt.Sprintf("edgevpn-%s", v))
-}
-
-func listDir(dir string) ([]string, error) {
-	content := []string{}
-
-	err := filepath.Walk(dir,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			content = append(content, path)
-
-			return nil
-		})
-
-	return content, err
+	return filepath.Join(runtimesDir(), fmt.Sprintf("edgevpn-%s", v))
 }
 
 func availableVersions() (versions []string) {
-	files, _ := listDir(filepath.Join(stateDir(), "bin"))
+	files, _ := ioutil.ReadDir(runtimesDir())
 	for _, f := range files {
-		v := strings.ReplaceAll(filepath.Base(f), "edgevpn-", "")
+		if f.IsDir() || !strings.HasPrefix(f.Name(), "edgevpn-") {
+			continue
+		}
+		v := strings.TrimPrefix(f.Name(), "edgevpn-")
 		if strings.HasPrefix(v, "v") {
 			versions = append(versions, v)
 		}
 	}
+	sortVersions(versions)
 	return
 }
 
+// latestInstalledVersion returns the highest stable version downloaded
+// locally, falling back to the highest pre-release if no stable one is installed.
+func latestInstalledVersion() string {
+	versions := availableVersions()
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !isPrerelease(versions[i]) {
+			return versions[i]
+		}
+	}
+	if len(versions) > 0 {
+		return versions[len(versions)-1]
+	}
+	return ""
+}
+
+// installedSize returns the size on disk of a downloaded version
+func installedSize(v string) int64 {
+	info, err := os.Stat(binaryVersion(v))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func humanSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
 func newHTTPClient(ctx context.Context, token string) *http.Client {
 	if token == "" {
 		return http.DefaultClient
@@ -80,13 +126,21 @@ func newHTTPClient(ctx context.Context, token string) *http.Client {
 	return oauth2.NewClient(ctx, src)
 }
 
-func (f *releaseFinder) findAll(slug string) ([]string, error) {
+type release struct {
+	Name       string
+	Notes      string
+	Published  time.Time
+	Prerelease bool
+	Draft      bool
+}
+
+func (f *releaseFinder) listReleases(slug string) ([]*github.RepositoryRelease, error) {
 	repo := strings.Split(slug, "/")
 	if len(repo) != 2 || repo[0] == "" || repo[1] == "" {
 		return nil, fmt.Errorf("Invalid slug format. It should be 'owner/name': %s", slug)
 	}
 
-	rels, res, err := f.api.Repositories.ListReleases(f.apiCtx, repo[0], repo[1], nil)
+	rels, res, err := f.api.Repositories.ListReleases(f.apiCtx, repo[0], repo[1], &github.ListOptions{PerPage: 100})
 	if err != nil {
 		log.Println("API returned an error response:", err)
 		if res != nil && res.StatusCode == 404 {
@@ -97,54 +151,56 @@ func (f *releaseFinder) findAll(slug string) ([]string, error) {
 		return nil, err
 	}
 
-	versions := []string{}
-	for _, rel := range rels {
-		versions = append(versions, *rel.Name)
+	// Newest first
+	sort.SliceStable(rels, func(i, j int) bool {
+		return compareVersions(rels[i].GetName(), rels[j].GetName()) > 0
+	})
+	return rels, nil
+}
 
+// findAll returns all the releases of the repository, sorted from the newest
+func (f *releaseFinder) findAll(slug string) ([]release, error) {
+	rels, err := f.listReleases(slug)
+	if err != nil {
+		return nil, err
 	}
-	return versions, nil
-}
 
-func (f *releaseFinder) find(slug string, version string) (*github.RepositoryRelease, *github.ReleaseAsset, error) {
-	repo := strings.Split(slug, "/")
-	if len(repo) != 2 || repo[0] == "" || repo[1] == "" {
-		return nil, nil, fmt.Errorf("Invalid slug format. It should be 'owner/name': %s", slug)
+	releases := []release{}
+	for _, rel := range rels {
+		releases = append(releases, release{
+			Name:       rel.GetName(),
+			Notes:      rel.GetBody(),
+			Published:  rel.GetPublishedAt().Time,
+			Prerelease: rel.GetPrerelease() || isPrerelease(rel.GetName()),
+			Draft:      rel.GetDraft(),
+		})
 	}
+	return releases, nil
+}
 
-	rels, res, err := f.api.Repositories.ListReleases(f.apiCtx, repo[0], repo[1], nil)
+// find returns the release matching version and its asset for the current platform.
+// If version is empty, the newest stable release is returned.
+func (f *releaseFinder) find(slug string, version string) (*github.RepositoryRelease, *github.ReleaseAsset, error) {
+	rels, err := f.listReleases(slug)
 	if err != nil {
-		log.Println("API returned an error response:", err)
-		if res != nil && res.StatusCode == 404 {
-			// 404 means repository not found or release not found. It's not an error here.
-			err = nil
-			log.Println("API returned 404. Repository or release not found")
-		}
 		return nil, nil, err
 	}
 
-	if version == "" {
-		a := findAsset(rels[0].Assets)
+	for _, rel := range rels {
+		if version == "" && (rel.GetDraft() || rel.GetPrerelease() || isPrerelease(rel.GetName())) {
+			continue
+		}
+		if version != "" && rel.GetName() != version {
+			continue
+		}
+		a := findAsset(rel.Assets)
 		if a == nil {
 			return nil, nil, fmt.Errorf("cannot find asset for '%s' '%s'", slug, version)
 		}
 
-		return rels[0], a, nil
+		return rel, a, nil
 	}
-	for _, rel := range rels {
-		fmt.Println("=====")
-		fmt.Println(*rel.Name)
-		if *rel.Name == version {
-			a := findAsset(rel.Assets)
-			if a == nil {
-				return nil, nil, fmt.Errorf("cannot find asset for '%s' '%s'", slug, version)
-			}
-
-			return rel, a, nil
-		}
 
-	}
-	//	url := asset.GetBrowserDownloadURL()
-	//	log.Println("Successfully fetched the latest release. tag:", rel.GetTagName(), ", name:", rel.GetName(), ", URL:", rel.GetURL(), ", Asset:", url)
 	return nil, nil, fmt.Errorf("No good release found for '%s' '%s'", slug, version)
 }
 
@@ -0,0 +1,211 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+const defaultKeepVersions = 2
+
+// runtimeUsage describes an installed EdgeVPN version
+type runtimeUsage struct {
+	Version  string
+	Size     int64
+	Profiles []*vpn
+}
+
+func (r runtimeUsage) profileNames() (names []string) {
+	for _, p := range r.Profiles {
+		names = append(names, p.Name)
+	}
+	return
+}
+
+// runtimeInventory returns the installed versions, from the oldest,
+// along with the connections using them.
+func runtimeInventory(connections []*vpn) (inventory []runtimeUsage) {
+	for _, v := range availableVersions() {
+		r := runtimeUsage{Version: v, Size: installedSize(v)}
+		for _, c := range connections {
+			if c.RuntimeVersion == v {
+				r.Profiles = append(r.Profiles, c)
+			}
+		}
+		inventory = append(inventory, r)
+	}
+	return
+}
+
+func diskUsage(inventory []runtimeUsage) (total int64) {
+	for _, r := range inventory {
+		total += r.Size
+	}
+	return
+}
+
+// unusedVersions returns the installed versions which are not used by any
+// connection, sparing the newest keep versions.
+func unusedVersions(connections []*vpn, keep int) (unused []string) {
+	inventory := runtimeInventory(connections)
+	for i, r := range inventory {
+		if i >= len(inventory)-keep {
+			break
+		}
+		if len(r.Profiles) == 0 {
+			unused = append(unused, r.Version)
+		}
+	}
+	return
+}
+
+func (m *VersionsManager) connections() []*vpn {
+	if m.dashboard == nil {
+		return nil
+	}
+	return m.dashboard.connections()
+}
+
+// remove deletes an installed version. If the version is used by any connection,
+// it asks to reassign them to another version first.
+func (m *VersionsManager) remove(app fyne.App, v string) {
+	var users []*vpn
+	for _, c := range m.connections() {
+		if c.RuntimeVersion == v {
+			users = append(users, c)
+		}
+	}
+
+	removeVersion := func() {
+		os.RemoveAll(binaryVersion(v))
+		if m.dashboard != nil {
+			m.dashboard.Reload(app)
+		}
+		m.showUI(app)
+	}
+
+	if len(users) == 0 {
+		dialog.NewConfirm(
+			"Delete",
+			fmt.Sprintf("Are you sure you want to delete version %v?",
+				v),
+			func(b bool) {
+				if b {
+					removeVersion()
+				}
+			}, m.window).Show()
+		return
+	}
+
+	others := []string{}
+	for _, s := range selectableVersions() {
+		if s != v {
+			others = append(others, s)
+		}
+	}
+	if len(others) == 0 {
+		errorWindow(fmt.Errorf("version %s is used by %s and no other version is available",
+			v, strings.Join(runtimeUsage{Profiles: users}.profileNames(), ", ")), m.window)
+		return
+	}
+
+	reassign := widget.NewSelect(others, func(string) {})
+	reassign.SetSelected(others[len(others)-1])
+	text := widget.NewLabel(
+		fmt.Sprintf("Version %s is used by %s. Select the version to use instead:",
+			v, strings.Join(runtimeUsage{Profiles: users}.profileNames(), ", ")),
+	)
+	text.Wrapping = fyne.TextWrapWord
+
+	dialog.NewCustomConfirm(
+		"Version in use",
+		"Reassign and delete",
+		"Cancel",
+		container.NewVBox(text, reassign),
+		func(b bool) {
+			if !b {
+				return
+			}
+			target := reassign.Selected
+			if target == "system" {
+				target = ""
+			}
+			go func() {
+				failed := []string{}
+				for _, c := range users {
+					if err := c.switchVersion(app, target); err != nil {
+						failed = append(failed, fmt.Sprintf("%s: %s", c.Name, err.Error()))
+					}
+				}
+				if len(failed) != 0 {
+					errorWindow(fmt.Errorf("failed reassigning connections, version %s was kept:\n%s", v, strings.Join(failed, "\n")), m.window)
+					m.showUI(app)
+					return
+				}
+				removeVersion()
+			}()
+		}, m.window).Show()
+}
+
+// clean removes the installed versions not used by any connection, keeping the newest ones
+func (m *VersionsManager) clean(app fyne.App) {
+	keep := widget.NewSelect([]string{"0", "1", "2", "3", "5"}, func(string) {})
+	keep.SetSelected(strconv.Itoa(defaultKeepVersions))
+
+	dialog.NewCustomConfirm(
+		"Clean unused versions",
+		"Clean",
+		"Cancel",
+		widget.NewForm(widget.NewFormItem("Keep newest", keep)),
+		func(b bool) {
+			if !b {
+				return
+			}
+			n, _ := strconv.Atoi(keep.Selected)
+			unused := unusedVersions(m.connections(), n)
+			if len(unused) == 0 {
+				dialog.NewInformation("Clean unused versions", "No unused versions to remove", m.window).Show()
+				return
+			}
+
+			var size int64
+			for _, v := range unused {
+				size += installedSize(v)
+			}
+			dialog.NewConfirm(
+				"Clean unused versions",
+				fmt.Sprintf("The following versions will be deleted, freeing %s:\n%s",
+					humanSize(size), strings.Join(unused, ", ")),
+				func(b bool) {
+					if !b {
+						return
+					}
+					for _, v := range unused {
+						os.RemoveAll(binaryVersion(v))
+					}
+					app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Removed %d unused versions", len(unused))))
+					m.showUI(app)
+				}, m.window).Show()
+		}, m.window).Show()
+}
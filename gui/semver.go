@@ -0,0 +1,143 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type semVersion struct {
+	major, minor, patch int
+	pre                 []string
+	valid               bool
+}
+
+// parseVersion parses versions in the form of v1.2.3-rc.1+build.
+// Missing minor and patch components are treated as zero.
+func parseVersion(v string) semVersion {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+
+	s := semVersion{}
+	core := v
+	if i := strings.Index(v, "-"); i >= 0 {
+		core = v[:i]
+		s.pre = strings.Split(v[i+1:], ".")
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return s
+	}
+	nums := [3]int{}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return s
+		}
+		nums[i] = n
+	}
+	s.major, s.minor, s.patch = nums[0], nums[1], nums[2]
+	s.valid = true
+	return s
+}
+
+func (s semVersion) isPrerelease() bool {
+	return len(s.pre) != 0
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a, b []string) int {
+	// A version without prerelease has higher precedence
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a) && i < len(b); i++ {
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(a), len(b))
+}
+
+// compareVersions returns -1, 0 or 1 if a is respectively lower, equal or
+// greater than b. Versions that can't be parsed sort before valid ones
+// and are compared lexically between themselves.
+func compareVersions(a, b string) int {
+	va, vb := parseVersion(a), parseVersion(b)
+	switch {
+	case !va.valid && !vb.valid:
+		return strings.Compare(a, b)
+	case !va.valid:
+		return -1
+	case !vb.valid:
+		return 1
+	}
+
+	if c := compareInt(va.major, vb.major); c != 0 {
+		return c
+	}
+	if c := compareInt(va.minor, vb.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(va.patch, vb.patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(va.pre, vb.pre)
+}
+
+// sortVersions sorts versions in ascending order
+func sortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+}
+
+func isPrerelease(v string) bool {
+	return parseVersion(v).isPrerelease()
+}
@@ -0,0 +1,261 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	process "github.com/mudler/go-processmanager"
+)
+
+const (
+	// serviceExpose exposes a local address to the network
+	serviceExpose = "expose"
+	// serviceConnect binds a local port to a service of the network
+	serviceConnect = "connect"
+)
+
+type service struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Kind    string `json:"kind"`
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// safeName returns name stripped of any character that is not safe in a path element
+func safeName(name string) string {
+	return unsafeChars.ReplaceAllString(name, "_")
+}
+
+func (s service) command() string {
+	if s.Kind == serviceConnect {
+		return "service-connect"
+	}
+	return "service-add"
+}
+
+func (s service) String() string {
+	if s.Kind == serviceConnect {
+		return fmt.Sprintf("%s → %s", s.Name, s.Address)
+	}
+	return fmt.Sprintf("%s ← %s", s.Name, s.Address)
+}
+
+func (s service) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("service name can't be empty")
+	}
+	if s.Kind != serviceExpose && s.Kind != serviceConnect {
+		return fmt.Errorf("invalid service kind '%s'", s.Kind)
+	}
+	return validateAddress(fmt.Sprintf("service '%s'", s.Name), s.Address)
+}
+
+func validateServices(services []service) error {
+	seen := map[string]bool{}
+	for _, s := range services {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		key := s.Kind + "/" + s.Name
+		if seen[key] {
+			return fmt.Errorf("service '%s' is defined twice", s.Name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func (c *vpn) serviceDir(s service) string {
+	return filepath.Join(c.stateDir, "services", fmt.Sprintf("%s-%s", s.Kind, safeName(s.Name)))
+}
+
+func (c *vpn) serviceAlive(s service) bool {
+	return process.New(process.WithStateDir(c.serviceDir(s))).IsAlive()
+}
+
+func (c *vpn) startService(s service) error {
+	_, err := c.runCommand(c.serviceDir(s), s.command(), s.Name, s.Address)
+	return err
+}
+
+func (c *vpn) stopService(s service) {
+	stopCommand(c.serviceDir(s))
+}
+
+func (c *vpn) stopServices() {
+	for _, s := range c.Services {
+		c.stopService(s)
+	}
+}
+
+func (c *vpn) serviceRow(app fyne.App, w fyne.Window, s service, refresh func()) fyne.CanvasObject {
+	status := "stopped"
+	alive := c.serviceAlive(s)
+	if alive {
+		status = "running"
+	}
+
+	var toggle *widget.Button
+	if alive {
+		toggle = widget.NewButtonWithIcon("Stop",
+			theme.MediaStopIcon(),
+			func() {
+				c.stopService(s)
+				refresh()
+			})
+	} else {
+		toggle = widget.NewButtonWithIcon("Start",
+			theme.MediaPlayIcon(),
+			func() {
+				if err := c.startService(s); err != nil {
+					errorWindow(err, w)
+					return
+				}
+				go func() {
+					time.Sleep(startupGracePeriod)
+					if !c.serviceAlive(s) {
+						app.SendNotification(
+							fyne.NewNotification(
+								"service failed",
+								fmt.Sprintf("failed starting service '%s'", s.Name),
+							))
+					}
+					refresh()
+				}()
+			})
+	}
+	toggle.Importance = widget.LowImportance
+
+	logs := widget.NewButtonWithIcon("",
+		theme.FileTextIcon(),
+		c.logs(app, c.serviceDir(s)),
+	)
+	logs.Importance = widget.LowImportance
+
+	remove := widget.NewButtonWithIcon("",
+		theme.DeleteIcon(),
+		func() {
+			dialog.NewConfirm(
+				"Delete",
+				fmt.Sprintf("Are you sure you want to delete the service '%s'?", s.Name),
+				func(b bool) {
+					if !b {
+						return
+					}
+					c.stopService(s)
+					services := []service{}
+					for _, ss := range c.Services {
+						if ss != s {
+							services = append(services, ss)
+						}
+					}
+					c.Services = services
+					if err := c.writeJSON(); err != nil {
+						errorWindow(err, w)
+					}
+					refresh()
+				}, w).Show()
+		})
+	remove.Importance = widget.LowImportance
+
+	return container.NewHBox(
+		widget.NewLabel(fmt.Sprintf("%s (%s)", s.String(), status)),
+		layout.NewSpacer(),
+		toggle, logs, remove,
+	)
+}
+
+func (c *vpn) servicesUI(app fyne.App, w fyne.Window) fyne.CanvasObject {
+	list := container.NewVBox()
+
+	var refresh func()
+	refresh = func() {
+		objs := []fyne.CanvasObject{}
+		for _, s := range c.Services {
+			objs = append(objs, c.serviceRow(app, w, s, refresh))
+		}
+		if len(objs) == 0 {
+			objs = append(objs, widget.NewLabel("No services defined"))
+		}
+		list.Objects = objs
+		list.Refresh()
+	}
+	refresh()
+
+	name := widget.NewEntry()
+	address := widget.NewEntry()
+	kinds := map[string]string{
+		"Expose a local service": serviceExpose,
+		"Connect to a service":   serviceConnect,
+	}
+	kind := widget.NewSelect([]string{"Expose a local service", "Connect to a service"}, func(k string) {
+		if kinds[k] == serviceConnect {
+			address.SetPlaceHolder("local listen address, e.g. :8080")
+		} else {
+			address.SetPlaceHolder("local service address, e.g. 127.0.0.1:80")
+		}
+	})
+	kind.SetSelected("Expose a local service")
+
+	add := widget.NewButtonWithIcon("Add service",
+		theme.ContentAddIcon(),
+		func() {
+			s := service{
+				Name:    name.Text,
+				Address: address.Text,
+				Kind:    kinds[kind.Selected],
+			}
+			services := append(append([]service{}, c.Services...), s)
+			if err := validateServices(services); err != nil {
+				errorWindow(err, w)
+				return
+			}
+			c.Services = services
+			if err := c.writeJSON(); err != nil {
+				errorWindow(err, w)
+				return
+			}
+			name.SetText("")
+			address.SetText("")
+			refresh()
+		})
+
+	form := widget.NewForm(
+		widget.NewFormItem("Type", kind),
+		widget.NewFormItem("Name", name),
+		widget.NewFormItem("Address", address),
+	)
+
+	return container.NewBorder(
+		nil,
+		container.NewVBox(widget.NewSeparator(), form, add),
+		nil,
+		nil,
+		container.NewVScroll(list),
+	)
+}
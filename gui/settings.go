@@ -0,0 +1,362 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	settingsFile = "settings.json"
+
+	themeSystem = "System"
+	themeLight  = "Light"
+	themeDark   = "Dark"
+
+	defaultReleaseRepository = "mudler/edgevpn"
+	defaultInterface         = "edgevpn0"
+	defaultAPIAddress        = ":8080"
+)
+
+var themes = []string{themeSystem, themeLight, themeDark}
+
+// settings are the application-wide preferences, stored in the config directory
+type settings struct {
+	Theme         string `json:"theme"`
+	Notifications bool   `json:"notifications"`
+	UpdateChecks  bool   `json:"update_checks"`
+	// CloseToTray hides the dashboard when closed, instead of closing it
+	CloseToTray bool `json:"close_to_tray"`
+	// InviteHandler registers the GUI as the handler of edgevpn:// invite links
+	InviteHandler bool `json:"invite_handler"`
+
+	// ReleaseRepository is the GitHub repository EdgeVPN releases are downloaded from
+	ReleaseRepository string `json:"release_repository"`
+	// GitHubToken is used for the GitHub API, to avoid its rate limits
+	GitHubToken string `json:"github_token,omitempty"`
+
+	DefaultInterface  string `json:"default_interface"`
+	DefaultAPIAddress string `json:"default_api_address"`
+	DefaultIPPool     string `json:"default_ip_pool"`
+
+	// DashboardSort and DashboardView are the sort order and the view of the connections
+	DashboardSort string `json:"dashboard_sort,omitempty"`
+	DashboardView string `json:"dashboard_view,omitempty"`
+
+	// StateDir keeps the profiles and EdgeVPN versions in a custom directory,
+	// applied on the next start. --state-dir and the portable mode take precedence.
+	StateDir string `json:"state_dir,omitempty"`
+}
+
+var (
+	prefs       settings
+	prefsLoaded bool
+	prefsMutex  sync.Mutex
+)
+
+func defaultSettings() settings {
+	return settings{
+		Theme:             themeSystem,
+		Notifications:     true,
+		UpdateChecks:      true,
+		CloseToTray:       true,
+		ReleaseRepository: defaultReleaseRepository,
+		DefaultInterface:  defaultInterface,
+		DefaultAPIAddress: defaultAPIAddress,
+		DefaultIPPool:     defaultIPPool,
+	}
+}
+
+// readSettings reads the settings in dir. Missing settings keep their default.
+func readSettings(dir string) (settings, error) {
+	s := defaultSettings()
+	dat, err := ioutil.ReadFile(filepath.Join(dir, settingsFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(dat, &s); err != nil {
+		return defaultSettings(), fmt.Errorf("invalid settings file: %w", err)
+	}
+	def := defaultSettings()
+	if s.ReleaseRepository == "" {
+		s.ReleaseRepository = def.ReleaseRepository
+	}
+	if s.DefaultInterface == "" {
+		s.DefaultInterface = def.DefaultInterface
+	}
+	if s.DefaultAPIAddress == "" {
+		s.DefaultAPIAddress = def.DefaultAPIAddress
+	}
+	if s.DefaultIPPool == "" {
+		s.DefaultIPPool = def.DefaultIPPool
+	}
+	return s, nil
+}
+
+// currentSettings returns the settings, reading them on first use
+func currentSettings() settings {
+	prefsMutex.Lock()
+	defer prefsMutex.Unlock()
+	if !prefsLoaded {
+		s, err := readSettings(configDir())
+		if err != nil {
+			log.Println(err)
+		}
+		prefs = s
+		prefsLoaded = true
+	}
+	return prefs
+}
+
+func (s settings) validate() error {
+	if s.ReleaseRepository != defaultReleaseRepository {
+		if parts := strings.Split(s.ReleaseRepository, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("the release repository should be 'owner/name': %s", s.ReleaseRepository)
+		}
+	}
+	if strings.TrimSpace(s.DefaultInterface) == "" {
+		return fmt.Errorf("the default interface can't be empty")
+	}
+	if _, _, err := net.SplitHostPort(s.DefaultAPIAddress); err != nil {
+		return fmt.Errorf("invalid default API listen address: %w", err)
+	}
+	if _, _, err := net.ParseCIDR(s.DefaultIPPool); err != nil {
+		return fmt.Errorf("invalid default IP pool: %w", err)
+	}
+	if s.StateDir != "" && !filepath.IsAbs(s.StateDir) {
+		return fmt.Errorf("the state directory should be an absolute path: %s", s.StateDir)
+	}
+	return nil
+}
+
+// saveSettings validates and stores s, which is used from now on
+func saveSettings(s settings) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	dat, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configDir(), os.ModePerm); err != nil {
+		return err
+	}
+	// The settings may contain a GitHub token
+	if err := ioutil.WriteFile(filepath.Join(configDir(), settingsFile), dat, 0600); err != nil {
+		return err
+	}
+
+	prefsMutex.Lock()
+	prefs = s
+	prefsLoaded = true
+	prefsMutex.Unlock()
+	return nil
+}
+
+// notifier sends the application notifications only if they are enabled in the settings
+type notifier struct {
+	fyne.App
+}
+
+func (n *notifier) SendNotification(notification *fyne.Notification) {
+	if currentSettings().Notifications {
+		n.App.SendNotification(notification)
+	}
+}
+
+// SetSystemTrayMenu and SetSystemTrayIcon implement desktop.App when the wrapped app does
+func (n *notifier) SetSystemTrayMenu(menu *fyne.Menu) {
+	if desk, ok := n.App.(desktop.App); ok {
+		desk.SetSystemTrayMenu(menu)
+	}
+}
+
+func (n *notifier) SetSystemTrayIcon(icon fyne.Resource) {
+	if desk, ok := n.App.(desktop.App); ok {
+		desk.SetSystemTrayIcon(icon)
+	}
+}
+
+var settingsWindow fyne.Window
+
+// showSettings opens the settings window, or focuses it if already open
+func showSettings(app fyne.App) {
+	if settingsWindow != nil {
+		settingsWindow.RequestFocus()
+		return
+	}
+	w := app.NewWindow("Settings")
+	settingsWindow = w
+	// The theme is previewed while selected, and restored on close if not saved
+	w.SetOnClosed(func() {
+		settingsWindow = nil
+		app.Settings().SetTheme(currentSettings().theme())
+	})
+
+	s := currentSettings()
+
+	themeSelect := widget.NewSelect(themes, func(t string) {
+		app.Settings().SetTheme(settings{Theme: t}.theme())
+	})
+	themeSelect.SetSelected(s.Theme)
+	if themeSelect.Selected == "" {
+		themeSelect.SetSelected(themeSystem)
+	}
+	notifications := widget.NewCheck("Show notifications", func(bool) {})
+	notifications.SetChecked(s.Notifications)
+	updates := widget.NewCheck("Check for EdgeVPN updates", func(bool) {})
+	updates.SetChecked(s.UpdateChecks)
+	closeToTray := widget.NewCheck("Keep running in the tray when the dashboard is closed", func(bool) {})
+	closeToTray.SetChecked(s.CloseToTray)
+	inviteHandler := widget.NewCheck("Open edgevpn:// invite links with this application", func(bool) {})
+	inviteHandler.SetChecked(s.InviteHandler)
+	if currentDirs().portable {
+		inviteHandler.Disable()
+	}
+
+	repository := widget.NewEntry()
+	repository.SetText(s.ReleaseRepository)
+	repository.SetPlaceHolder(defaultReleaseRepository)
+	githubToken := widget.NewPasswordEntry()
+	githubToken.SetText(s.GitHubToken)
+	githubToken.SetPlaceHolder("optional, raises the API rate limit")
+
+	iface := widget.NewEntry()
+	iface.SetText(s.DefaultInterface)
+	apiAddress := widget.NewEntry()
+	apiAddress.SetText(s.DefaultAPIAddress)
+	pool := widget.NewEntry()
+	pool.SetText(s.DefaultIPPool)
+
+	state := widget.NewEntry()
+	state.SetText(s.StateDir)
+	state.SetPlaceHolder(stateDir())
+	stateBrowse := widget.NewButtonWithIcon("", theme.FolderOpenIcon(), func() {
+		dialog.NewFolderOpen(func(u fyne.ListableURI, err error) {
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			if u != nil {
+				state.SetText(u.Path())
+			}
+		}, w).Show()
+	})
+	// The settings can't change a state directory given on the command line or the portable one
+	if d := currentDirs(); d.portable || d.fixed {
+		state.Disable()
+		stateBrowse.Disable()
+	}
+
+	form := &widget.Form{
+		Items: []*widget.FormItem{
+			widget.NewFormItem("Theme", themeSelect),
+			widget.NewFormItem("Notifications", notifications),
+			widget.NewFormItem("Updates", updates),
+			widget.NewFormItem("Close", closeToTray),
+			widget.NewFormItem("Invites", inviteHandler),
+			widget.NewFormItem("Release repository", repository),
+			widget.NewFormItem("GitHub token", githubToken),
+			widget.NewFormItem("Default interface", iface),
+			widget.NewFormItem("Default API listen address", apiAddress),
+			widget.NewFormItem("Default IP pool", pool),
+			widget.NewFormItem("State directory", container.NewBorder(nil, nil, nil, stateBrowse, state)),
+		},
+		OnSubmit: func() {
+			n := s
+			n.Theme = themeSelect.Selected
+			n.Notifications = notifications.Checked
+			n.UpdateChecks = updates.Checked
+			n.CloseToTray = closeToTray.Checked
+			n.InviteHandler = inviteHandler.Checked
+			n.ReleaseRepository = strings.TrimSpace(repository.Text)
+			if n.ReleaseRepository == "" {
+				n.ReleaseRepository = defaultReleaseRepository
+			}
+			n.GitHubToken = strings.TrimSpace(githubToken.Text)
+			n.DefaultInterface = strings.TrimSpace(iface.Text)
+			n.DefaultAPIAddress = strings.TrimSpace(apiAddress.Text)
+			n.DefaultIPPool = strings.TrimSpace(pool.Text)
+			n.StateDir = strings.TrimSpace(state.Text)
+
+			if err := n.validate(); err != nil {
+				errorWindow(err, w)
+				return
+			}
+
+			save := func() {
+				if n.InviteHandler != s.InviteHandler {
+					if err := setInviteHandler(n.InviteHandler); err != nil {
+						errorWindow(err, w)
+						return
+					}
+				}
+				if err := saveSettings(n); err != nil {
+					errorWindow(err, w)
+					return
+				}
+				w.Close()
+			}
+			if n.StateDir == s.StateDir {
+				save()
+				return
+			}
+
+			target := n.StateDir
+			if target == "" {
+				target = "the default directory"
+			}
+			text := widget.NewLabel(fmt.Sprintf(
+				"%s is used from the next start. The profiles and EdgeVPN versions are not moved: they stay in %s, and can be moved after quitting the application.\n\nConnections still running won't be managed anymore: stop them before restarting.",
+				target, stateDir(),
+			))
+			text.Wrapping = fyne.TextWrapWord
+			d := dialog.NewCustomConfirm("Change the state directory", "Change", "Cancel",
+				container.NewGridWrap(fyne.NewSize(400, 140), text),
+				func(b bool) {
+					if b {
+						save()
+					}
+				}, w)
+			d.Show()
+		},
+		OnCancel:   w.Close,
+		SubmitText: "Save",
+	}
+
+	w.SetContent(form)
+	w.Resize(fyne.NewSize(520, 0))
+	w.CenterOnScreen()
+	w.Show()
+}
@@ -0,0 +1,230 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/storage"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/go-vgo/robotgo/clipboard"
+	"github.com/makiuchi-d/gozxing"
+	qrreader "github.com/makiuchi-d/gozxing/qrcode"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const qrSize = 320
+
+const (
+	shareProfile = "Profile"
+	shareToken   = "Token only"
+)
+
+// shared returns the connection profile as it can be given to another machine:
+// the IP and paths local to this machine are not included.
+func (c *vpn) shared() vpn {
+	d := *c
+	d.ID = ""
+	d.IP = ""
+	d.RuntimePath = ""
+	d.ReceiveDir = ""
+	if d.RuntimeVersion == customRuntime {
+		d.RuntimeVersion = ""
+	}
+	return d
+}
+
+// sharePayload returns the text encoded in the QR code of the connection
+func (c *vpn) sharePayload(kind string) (string, error) {
+	if kind == shareToken {
+		return c.Token, nil
+	}
+	dat, err := json.Marshal(c.shared())
+	if err != nil {
+		return "", err
+	}
+	return string(dat), nil
+}
+
+// parseShared decodes the content of a shared QR code, either a
+// connection profile or a bare token.
+func parseShared(text string) (*vpn, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "{") {
+		return parseProfile([]byte(text))
+	}
+	if err := checkToken(text); err != nil {
+		return nil, fmt.Errorf("not a connection profile or a valid token: %w", err)
+	}
+	return &vpn{Token: text}, nil
+}
+
+// decodeQR returns the text of the QR code in a PNG or JPEG image
+func decodeQR(r io.Reader) (string, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return "", err
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", err
+	}
+	res, err := qrreader.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		return "", fmt.Errorf("no QR code found in the image: %w", err)
+	}
+	return res.GetText(), nil
+}
+
+func (c *vpn) showQR(app fyne.App) {
+	w := app.NewWindow(fmt.Sprintf("Share %s", c.Name))
+
+	var png []byte
+	image := canvas.NewImageFromResource(nil)
+	image.FillMode = canvas.ImageFillContain
+	image.SetMinSize(fyne.NewSize(qrSize, qrSize))
+	status := widget.NewLabel("")
+	status.Wrapping = fyne.TextWrapWord
+
+	kind := widget.NewRadioGroup([]string{shareProfile, shareToken}, func(s string) {
+		payload, err := c.sharePayload(s)
+		if err == nil {
+			png, err = qrcode.Encode(payload, qrcode.Medium, qrSize)
+		}
+		if err != nil {
+			png = nil
+			status.SetText(fmt.Sprintf("Failed generating the QR code: %s", err.Error()))
+			image.Resource = nil
+			image.Refresh()
+			return
+		}
+		if s == shareToken {
+			status.SetText("Scan to get the network token. Anyone with it can join the network.")
+		} else {
+			status.SetText("Scan to import the connection. The IP must be set on the new machine.")
+		}
+		image.Resource = fyne.NewStaticResource(fmt.Sprintf("%s.png", c.Name), png)
+		image.Refresh()
+	})
+	kind.Horizontal = true
+	kind.Required = true
+	kind.SetSelected(shareProfile)
+
+	save := widget.NewButtonWithIcon("Save image",
+		theme.DocumentSaveIcon(),
+		func() {
+			d := dialog.NewFileSave(
+				func(f fyne.URIWriteCloser, e error) {
+					if e != nil {
+						errorWindow(e, w)
+						return
+					}
+					if f == nil {
+						return
+					}
+					defer f.Close()
+					if _, err := f.Write(png); err != nil {
+						errorWindow(err, w)
+						return
+					}
+					app.SendNotification(fyne.NewNotification("info", "QR code saved"))
+				}, w)
+			d.SetFileName(fmt.Sprintf("%s.png", safeName(c.Name)))
+			d.Show()
+		})
+	copyText := widget.NewButtonWithIcon("Copy text",
+		theme.ContentCopyIcon(),
+		func() {
+			payload, err := c.sharePayload(kind.Selected)
+			if err != nil {
+				errorWindow(err, w)
+				return
+			}
+			clipboard.WriteAll(payload)
+			app.SendNotification(fyne.NewNotification("info", "Copied to clipboard"))
+		})
+
+	w.SetContent(container.NewBorder(
+		kind,
+		container.NewVBox(status, container.NewHBox(layout.NewSpacer(), copyText, save)),
+		nil,
+		nil,
+		image,
+	))
+	w.Show()
+}
+
+// importShared asks for a QR code image, or the text scanned from one,
+// and opens the new connection form with it to complete the settings.
+func (c *dashboard) importShared(app fyne.App) {
+	text := widget.NewMultiLineEntry()
+	text.SetPlaceHolder("Paste the text scanned from the QR code, or open an image of it")
+	text.Wrapping = fyne.TextWrapBreak
+
+	openImage := widget.NewButtonWithIcon("Open image",
+		theme.FileImageIcon(),
+		func() {
+			d := dialog.NewFileOpen(
+				func(f fyne.URIReadCloser, e error) {
+					if e != nil {
+						errorWindow(e, c.window)
+						return
+					}
+					if f == nil {
+						return
+					}
+					defer f.Close()
+					s, err := decodeQR(f)
+					if err != nil {
+						errorWindow(err, c.window)
+						return
+					}
+					text.SetText(s)
+				}, c.window)
+			d.SetFilter(storage.NewExtensionFileFilter([]string{".png", ".jpg", ".jpeg"}))
+			d.Show()
+		})
+
+	d := dialog.NewCustomConfirm("Import QR code", "Import", "Cancel",
+		container.NewBorder(nil, container.NewHBox(layout.NewSpacer(), openImage), nil, nil, container.NewGridWrap(fyne.NewSize(400, 200), text)),
+		func(b bool) {
+			if !b {
+				return
+			}
+			v, err := parseShared(text.Text)
+			if err != nil {
+				errorWindow(err, c.window)
+				return
+			}
+
+			v.parent = c
+			v.generateUI(app, false)
+		}, c.window)
+	d.Show()
+}
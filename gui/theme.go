@@ -0,0 +1,195 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"image/color"
+	"sync"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/theme"
+	"github.com/godbus/dbus/v5"
+)
+
+// Colors of the connection states
+const (
+	colorNameRunning fyne.ThemeColorName = "edgevpnRunning"
+	colorNameStopped fyne.ThemeColorName = "edgevpnStopped"
+	colorNameFailed  fyne.ThemeColorName = "edgevpnFailed"
+)
+
+var palette = map[fyne.ThemeVariant]map[fyne.ThemeColorName]color.Color{
+	theme.VariantLight: {
+		theme.ColorNamePrimary:   color.NRGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff},
+		theme.ColorNameFocus:     color.NRGBA{R: 0x33, G: 0x66, B: 0x99, A: 0x7f},
+		theme.ColorNameSelection: color.NRGBA{R: 0x33, G: 0x66, B: 0x99, A: 0x3f},
+		colorNameRunning:         color.NRGBA{R: 0x2e, G: 0x9d, B: 0x5a, A: 0xff},
+		colorNameStopped:         color.NRGBA{R: 0x8a, G: 0x8f, B: 0x98, A: 0xff},
+		colorNameFailed:          color.NRGBA{R: 0xd3, G: 0x3f, B: 0x49, A: 0xff},
+	},
+	theme.VariantDark: {
+		theme.ColorNamePrimary:   color.NRGBA{R: 0x66, G: 0xaa, B: 0xcc, A: 0xff},
+		theme.ColorNameFocus:     color.NRGBA{R: 0x66, G: 0xaa, B: 0xcc, A: 0x7f},
+		theme.ColorNameSelection: color.NRGBA{R: 0x66, G: 0xaa, B: 0xcc, A: 0x3f},
+		colorNameRunning:         color.NRGBA{R: 0x4c, G: 0xc3, B: 0x7a, A: 0xff},
+		colorNameStopped:         color.NRGBA{R: 0x6b, G: 0x70, B: 0x78, A: 0xff},
+		colorNameFailed:          color.NRGBA{R: 0xef, G: 0x5f, B: 0x67, A: 0xff},
+	},
+}
+
+// edgeTheme is the EdgeVPN theme. mode is one of themes: the light or dark
+// variant, or the one preferred by the desktop.
+type edgeTheme struct {
+	mode string
+}
+
+func (s settings) theme() fyne.Theme {
+	return &edgeTheme{mode: s.Theme}
+}
+
+func (t *edgeTheme) variant(v fyne.ThemeVariant) fyne.ThemeVariant {
+	switch t.mode {
+	case themeLight:
+		return theme.VariantLight
+	case themeDark:
+		return theme.VariantDark
+	}
+	if d, ok := desktopVariant(); ok {
+		return d
+	}
+	return v
+}
+
+func (t *edgeTheme) Color(n fyne.ThemeColorName, v fyne.ThemeVariant) color.Color {
+	v = t.variant(v)
+	if c, ok := palette[v][n]; ok {
+		return c
+	}
+	return theme.DefaultTheme().Color(n, v)
+}
+
+func (t *edgeTheme) Font(s fyne.TextStyle) fyne.Resource {
+	return theme.DefaultTheme().Font(s)
+}
+
+func (t *edgeTheme) Icon(n fyne.ThemeIconName) fyne.Resource {
+	return theme.DefaultTheme().Icon(n)
+}
+
+func (t *edgeTheme) Size(n fyne.ThemeSizeName) float32 {
+	return theme.DefaultTheme().Size(n)
+}
+
+// themeColor returns the color n of the current theme
+func themeColor(n fyne.ThemeColorName) color.Color {
+	s := fyne.CurrentApp().Settings()
+	return s.Theme().Color(n, s.ThemeVariant())
+}
+
+const (
+	portalDest      = "org.freedesktop.portal.Desktop"
+	portalPath      = "/org/freedesktop/portal/desktop"
+	portalSettings  = "org.freedesktop.portal.Settings"
+	appearanceGroup = "org.freedesktop.appearance"
+	colorSchemeKey  = "color-scheme"
+)
+
+var (
+	desktopScheme fyne.ThemeVariant
+	desktopKnown  bool
+	desktopMutex  sync.Mutex
+)
+
+// desktopVariant returns the variant preferred by the desktop, if it has a preference
+func desktopVariant() (fyne.ThemeVariant, bool) {
+	desktopMutex.Lock()
+	defer desktopMutex.Unlock()
+	return desktopScheme, desktopKnown
+}
+
+func setDesktopVariant(scheme uint32) {
+	desktopMutex.Lock()
+	defer desktopMutex.Unlock()
+	// 0 is no preference, 1 prefers dark and 2 prefers light
+	switch scheme {
+	case 1:
+		desktopScheme, desktopKnown = theme.VariantDark, true
+	case 2:
+		desktopScheme, desktopKnown = theme.VariantLight, true
+	default:
+		desktopKnown = false
+	}
+}
+
+// colorScheme returns the color scheme of a portal setting value, which may be nested in variants
+func colorScheme(v dbus.Variant) (uint32, bool) {
+	switch val := v.Value().(type) {
+	case uint32:
+		return val, true
+	case dbus.Variant:
+		return colorScheme(val)
+	}
+	return 0, false
+}
+
+// applyDesktopTheme applies the theme again if it follows the desktop
+func applyDesktopTheme(app fyne.App, scheme uint32) {
+	setDesktopVariant(scheme)
+	if s := currentSettings(); s.Theme == themeSystem {
+		app.Settings().SetTheme(s.theme())
+	}
+}
+
+// followDesktopTheme reads the color scheme of the desktop from the XDG settings portal
+// and applies the theme again when it changes. Desktops without the portal keep the
+// variant detected by Fyne. It blocks on D-Bus, so it is meant to run in a goroutine.
+func followDesktopTheme(app fyne.App) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return
+	}
+	var v dbus.Variant
+	if err := conn.Object(portalDest, portalPath).Call(portalSettings+".Read", 0, appearanceGroup, colorSchemeKey).Store(&v); err != nil {
+		return
+	}
+	if scheme, ok := colorScheme(v); ok {
+		applyDesktopTheme(app, scheme)
+	}
+
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(portalPath),
+		dbus.WithMatchInterface(portalSettings),
+		dbus.WithMatchMember("SettingChanged"),
+	); err != nil {
+		return
+	}
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	for s := range signals {
+		if s.Name != portalSettings+".SettingChanged" || len(s.Body) != 3 {
+			continue
+		}
+		group, _ := s.Body[0].(string)
+		key, _ := s.Body[1].(string)
+		value, _ := s.Body[2].(dbus.Variant)
+		if group != appearanceGroup || key != colorSchemeKey {
+			continue
+		}
+		if scheme, ok := colorScheme(value); ok {
+			applyDesktopTheme(app, scheme)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"gopkg.in/yaml.v3"
+)
+
+type otpConfig struct {
+	Interval int    `yaml:"interval"`
+	Key      string `yaml:"key"`
+	Length   int    `yaml:"length"`
+}
+
+// tokenConfig is the network configuration encoded in an EdgeVPN token
+type tokenConfig struct {
+	OTP struct {
+		DHT    otpConfig `yaml:"dht"`
+		Crypto otpConfig `yaml:"crypto"`
+	} `yaml:"otp"`
+	Room           string `yaml:"room"`
+	Rendezvous     string `yaml:"rendezvous"`
+	MDNS           string `yaml:"mdns"`
+	MaxMessageSize int    `yaml:"max_message_size"`
+}
+
+// decodeToken decodes a base64 encoded EdgeVPN token
+func decodeToken(token string) (*tokenConfig, error) {
+	dat, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	t := &tokenConfig{}
+	if err := yaml.Unmarshal(dat, t); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return t, nil
+}
+
+const (
+	defaultOTPInterval    = 9000
+	defaultKeyLength      = 32
+	defaultMaxMessageSize = 20 << 20
+)
+
+// tokenOptions are the settings used to generate a new network token.
+// Empty Rendezvous, Room and MDNS are generated randomly.
+type tokenOptions struct {
+	KeyLength      int
+	DHTInterval    int
+	CryptoInterval int
+	MaxMessageSize int
+	Rendezvous     string
+	Room           string
+	MDNS           string
+}
+
+func defaultTokenOptions() tokenOptions {
+	return tokenOptions{
+		KeyLength:      defaultKeyLength,
+		DHTInterval:    defaultOTPInterval,
+		CryptoInterval: defaultOTPInterval,
+		MaxMessageSize: defaultMaxMessageSize,
+	}
+}
+
+// options returns the settings of the token, without its secrets
+func (t *tokenConfig) options() tokenOptions {
+	return tokenOptions{
+		KeyLength:      t.OTP.DHT.Length,
+		DHTInterval:    t.OTP.DHT.Interval,
+		CryptoInterval: t.OTP.Crypto.Interval,
+		MaxMessageSize: t.MaxMessageSize,
+	}
+}
+
+const (
+	randomRunes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	base32Runes = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+)
+
+// randomRunesString returns n random characters of runes. Random bytes past
+// the last multiple of len(runes) are dropped, so that all runes are as likely.
+func randomRunesString(runes string, n int) (string, error) {
+	limit := 256 - 256%len(runes)
+	out := make([]byte, 0, n)
+	b := make([]byte, n)
+	for len(out) < n {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, r := range b {
+			if int(r) < limit && len(out) < n {
+				out = append(out, runes[int(r)%len(runes)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+func randomString(n int) (string, error) {
+	return randomRunesString(randomRunes, n)
+}
+
+// totpSecret returns a random base32 secret of n characters for OTP generation,
+// as EdgeVPN generates them
+func totpSecret(n int) (string, error) {
+	return randomRunesString(base32Runes, n)
+}
+
+// newTokenConfig generates the configuration of a new network
+func newTokenConfig(o tokenOptions) (*tokenConfig, error) {
+	switch {
+	case o.KeyLength <= 0:
+		return nil, fmt.Errorf("key length must be greater than zero")
+	case o.DHTInterval <= 0 || o.CryptoInterval <= 0:
+		return nil, fmt.Errorf("OTP intervals must be greater than zero")
+	case o.MaxMessageSize <= 0:
+		return nil, fmt.Errorf("max message size must be greater than zero")
+	}
+
+	t := &tokenConfig{MaxMessageSize: o.MaxMessageSize}
+	for _, f := range []struct {
+		dst   *string
+		value string
+	}{
+		{&t.Rendezvous, o.Rendezvous},
+		{&t.Room, o.Room},
+		{&t.MDNS, o.MDNS},
+	} {
+		if f.value != "" {
+			*f.dst = f.value
+			continue
+		}
+		r, err := randomString(o.KeyLength)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = r
+	}
+
+	dhtKey, err := totpSecret(o.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+	cryptoKey, err := totpSecret(o.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+	t.OTP.DHT = otpConfig{Key: dhtKey, Interval: o.DHTInterval, Length: o.KeyLength}
+	t.OTP.Crypto = otpConfig{Key: cryptoKey, Interval: o.CryptoInterval, Length: o.KeyLength}
+	return t, nil
+}
+
+// encode returns the base64 encoded token of the configuration
+func (t *tokenConfig) encode() (string, error) {
+	dat, err := yaml.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(dat), nil
+}
+
+// rotatedToken returns a new token with fresh secrets, keeping
+// the network settings of the current one when possible.
+func rotatedToken(current string) (string, error) {
+	o := defaultTokenOptions()
+	if t, err := decodeToken(current); err == nil {
+		if opts := t.options(); opts.KeyLength > 0 && opts.DHTInterval > 0 && opts.CryptoInterval > 0 && opts.MaxMessageSize > 0 {
+			o = opts
+		}
+	}
+	t, err := newTokenConfig(o)
+	if err != nil {
+		return "", err
+	}
+	return t.encode()
+}
+
+// maskSecret hides most of a secret, keeping a few characters to tell keys apart
+func maskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("•", len(s))
+	}
+	return s[:4] + strings.Repeat("•", 8)
+}
+
+// tokenForm returns the form items to customize the settings of a new
+// network, and a function generating the token from them.
+func tokenForm() ([]*widget.FormItem, func() (string, error)) {
+	d := defaultTokenOptions()
+
+	keyLength := widget.NewEntry()
+	keyLength.SetText(strconv.Itoa(d.KeyLength))
+	dhtInterval := widget.NewEntry()
+	dhtInterval.SetText(strconv.Itoa(d.DHTInterval))
+	cryptoInterval := widget.NewEntry()
+	cryptoInterval.SetText(strconv.Itoa(d.CryptoInterval))
+	maxMessageSize := widget.NewEntry()
+	maxMessageSize.SetText(strconv.Itoa(d.MaxMessageSize >> 20))
+	rendezvous := widget.NewEntry()
+	rendezvous.SetPlaceHolder("random")
+	room := widget.NewEntry()
+	room.SetPlaceHolder("random")
+	mdns := widget.NewEntry()
+	mdns.SetPlaceHolder("random")
+
+	generate := func() (string, error) {
+		ints := map[string]*widget.Entry{
+			"key length":            keyLength,
+			"DHT OTP interval":      dhtInterval,
+			"crypto OTP interval":   cryptoInterval,
+			"max message size (MB)": maxMessageSize,
+		}
+		values := map[string]int{}
+		for name, e := range ints {
+			v, err := strconv.Atoi(strings.TrimSpace(e.Text))
+			if err != nil {
+				return "", fmt.Errorf("invalid %s '%s'", name, e.Text)
+			}
+			values[name] = v
+		}
+
+		t, err := newTokenConfig(tokenOptions{
+			KeyLength:      values["key length"],
+			DHTInterval:    values["DHT OTP interval"],
+			CryptoInterval: values["crypto OTP interval"],
+			MaxMessageSize: values["max message size (MB)"] << 20,
+			Rendezvous:     rendezvous.Text,
+			Room:           room.Text,
+			MDNS:           mdns.Text,
+		})
+		if err != nil {
+			return "", err
+		}
+		return t.encode()
+	}
+
+	return []*widget.FormItem{
+		widget.NewFormItem("OTP key length", keyLength),
+		widget.NewFormItem("DHT OTP interval (s)", dhtInterval),
+		widget.NewFormItem("Crypto OTP interval (s)", cryptoInterval),
+		widget.NewFormItem("Rendezvous", rendezvous),
+		widget.NewFormItem("Room", room),
+		widget.NewFormItem("MDNS", mdns),
+		widget.NewFormItem("Max message size (MB)", maxMessageSize),
+	}, generate
+}
+
+func (c *vpn) inspectToken(app fyne.App, w fyne.Window) func() {
+	return func() {
+		t, err := decodeToken(c.Token)
+		if err != nil {
+			errorWindow(err, w)
+			return
+		}
+
+		otp := func(o otpConfig) string {
+			return fmt.Sprintf("key %s, length %d, interval %ds", maskSecret(o.Key), o.Length, o.Interval)
+		}
+
+		form := widget.NewForm(
+			widget.NewFormItem("Rendezvous", widget.NewLabel(t.Rendezvous)),
+			widget.NewFormItem("Room", widget.NewLabel(t.Room)),
+			widget.NewFormItem("MDNS", widget.NewLabel(t.MDNS)),
+			widget.NewFormItem("DHT OTP", widget.NewLabel(otp(t.OTP.DHT))),
+			widget.NewFormItem("Crypto OTP", widget.NewLabel(otp(t.OTP.Crypto))),
+			widget.NewFormItem("Max message size", widget.NewLabel(humanSize(int64(t.MaxMessageSize)))),
+		)
+
+		iw := app.NewWindow(fmt.Sprintf("Token %s", c.Name))
+		iw.SetContent(form)
+		iw.Show()
+	}
+}
+
+func (c *vpn) inspectTokenButton(app fyne.App, w fyne.Window) *widget.Button {
+	return widget.NewButtonWithIcon(
+		"",
+		theme.InfoIcon(),
+		c.inspectToken(app, w),
+	)
+}
+
+// exportToken asks where to save the token of the connection
+func (c *vpn) exportToken(app fyne.App, w fyne.Window) {
+	d := dialog.NewFileSave(
+		func(f fyne.URIWriteCloser, e error) {
+			if e != nil {
+				errorWindow(e, w)
+				return
+			}
+			if f == nil {
+				return
+			}
+			defer f.Close()
+			if _, err := f.Write([]byte(c.Token + "\n")); err != nil {
+				errorWindow(err, w)
+				return
+			}
+			app.SendNotification(fyne.NewNotification("info", "Token saved"))
+		}, w)
+	d.SetFileName(fmt.Sprintf("%s.token", safeName(c.Name)))
+	d.Show()
+}
+
+// rotateToken replaces the token of the connection with a newly generated one,
+// restarting the connection if it is running.
+func (c *vpn) rotateToken(app fyne.App, w fyne.Window) func() {
+	return func() {
+		dialog.NewConfirm(
+			"Rotate token",
+			"A new token will be generated and the connection restarted if running. Peers won't be able to reach this node until they are given the new token. Continue?",
+			func(b bool) {
+				if !b {
+					return
+				}
+				token, err := rotatedToken(c.Token)
+				if err != nil {
+					errorWindow(err, w)
+					return
+				}
+
+				running := c.isAlive()
+				// The connection keeps its token if the new one can't be saved
+				d := *c
+				d.Token = strings.TrimSpace(token)
+				if err := d.writeJSON(); err != nil {
+					errorWindow(err, w)
+					return
+				}
+				c.Token = d.Token
+
+				go func() {
+					if running {
+						if err := c.restart(app); err != nil {
+							errorWindow(err, w)
+						}
+					}
+					c.parent.Reload(app)
+					c.showDetails(w, app)
+					app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("Token of '%s' rotated", c.Name)))
+					c.exportToken(app, w)
+				}()
+			}, w).Show()
+	}
+}
@@ -1,27 +1,310 @@
 package gui
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/systray"
+)
+
+const trayRefreshInterval = 3 * time.Second
+
+// trayStatus is the overall state of the connections shown by the tray icon
+type trayStatus int
+
+const (
+	// trayIdle is shown when no connection is running
+	trayIdle trayStatus = iota
+	// trayRunning is shown when some connections are running
+	trayRunning
+	// trayFailed is shown when some connections failed
+	trayFailed
 )
 
+var trayColors = map[trayStatus]fyne.ThemeColorName{
+	trayIdle:    colorNameStopped,
+	trayRunning: colorNameRunning,
+	trayFailed:  colorNameFailed,
+}
+
+type tray struct {
+	app       fyne.App
+	dashboard *dashboard
+
+	mu    sync.Mutex
+	icons map[trayStatus][]byte
+	// last is the state of the connections the tray was last updated for
+	last string
+
+	// menu items, only on Linux
+	toggle, conns, stopAll *systray.MenuItem
+	slots                  []*traySlot
+}
+
+// traySlot is the tray submenu of a connection. Items can't be removed from
+// the tray, so slots are reused and hidden when not needed.
+type traySlot struct {
+	item, start, stop, logs *systray.MenuItem
+	conn                    *vpn
+}
+
 func makeTray(a fyne.App, d *dashboard) {
-	visible := true
-	if desk, ok := a.(desktop.App); ok {
-		menu := fyne.NewMenu("EdgeVPN",
+	t := &tray{app: a, dashboard: d, icons: map[trayStatus][]byte{}}
+
+	// Fyne builds the tray menu only once. On Linux the tray doesn't need the
+	// Fyne event loop, so it is driven directly to keep the menu up to date.
+	// Other platforms get a static menu without the connections: only the
+	// icon shows their state there.
+	if runtime.GOOS == "linux" {
+		start, _ := systray.RunWithExternalLoop(t.buildMenu, nil)
+		go start()
+	} else if desk, ok := a.(desktop.App); ok {
+		desk.SetSystemTrayMenu(fyne.NewMenu("EdgeVPN",
 			fyne.NewMenuItem("Show/Hide Dashboard", func() {
-				if visible {
-					d.window.Hide()
-					visible = false
-				} else {
-					d.window.Show()
-					visible = true
-				}
+				d.toggle(a)
+			}),
+			fyne.NewMenuItem("Stop all", t.stopAllConnections),
+			fyne.NewMenuItem("Settings", func() {
+				showSettings(a)
 			}),
 			fyne.NewMenuItem("About", func() {
 				about(a)
 			}),
-		)
-		desk.SetSystemTrayMenu(menu)
+		))
+	} else {
+		return
+	}
+
+	go func() {
+		tick := time.NewTicker(trayRefreshInterval)
+		defer tick.Stop()
+		for range tick.C {
+			t.update()
+		}
+	}()
+}
+
+func onClick(item *systray.MenuItem, f func()) {
+	go func() {
+		for range item.ClickedCh {
+			f()
+		}
+	}()
+}
+
+// buildMenu creates the tray menu, called once the tray is ready
+func (t *tray) buildMenu() {
+	systray.SetTitle("EdgeVPN")
+
+	t.mu.Lock()
+	t.toggle = systray.AddMenuItem("Hide dashboard", "")
+	t.conns = systray.AddMenuItem("Connections", "")
+	t.stopAll = systray.AddMenuItem("Stop all", "Stop all the running connections")
+	t.mu.Unlock()
+	onClick(t.toggle, func() {
+		t.dashboard.toggle(t.app)
+		t.update()
+	})
+	onClick(t.stopAll, t.stopAllConnections)
+
+	systray.AddSeparator()
+	onClick(systray.AddMenuItem("Settings", ""), func() {
+		showSettings(t.app)
+	})
+	onClick(systray.AddMenuItem("About", ""), func() {
+		about(t.app)
+	})
+	systray.AddSeparator()
+	onClick(systray.AddMenuItem("Quit", "Quit the application"), func() {
+		t.app.Quit()
+	})
+
+	t.update()
+}
+
+func (t *tray) addSlot() *traySlot {
+	s := &traySlot{item: t.conns.AddSubMenuItem("", "")}
+	s.start = s.item.AddSubMenuItem("Start", "")
+	s.stop = s.item.AddSubMenuItem("Stop", "")
+	s.logs = s.item.AddSubMenuItem("Logs", "")
+
+	action := func(f func(c *vpn)) func() {
+		return func() {
+			t.mu.Lock()
+			c := s.conn
+			t.mu.Unlock()
+			if c != nil {
+				f(c)
+			}
+		}
+	}
+	onClick(s.start, action(func(c *vpn) {
+		c.start(t.app, nil)()
+	}))
+	onClick(s.stop, action(func(c *vpn) {
+		c.kill()
+		t.app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("'%s' stopped", c.Name)))
+		t.dashboard.Reload(t.app)
+		t.update()
+	}))
+	onClick(s.logs, action(func(c *vpn) {
+		c.logs(t.app, c.processDir())()
+	}))
+	t.slots = append(t.slots, s)
+	return s
+}
+
+func showItem(item *systray.MenuItem, show bool) {
+	if show {
+		item.Show()
+	} else {
+		item.Hide()
+	}
+}
+
+// update refreshes the tray icon and menu if the state of the connections changed
+func (t *tray) update() {
+	conns := loadConnections(t.dashboard)
+	states := make([]string, len(conns))
+	status := trayIdle
+	running := 0
+	for i, c := range conns {
+		states[i], _ = c.connectionState()
+		switch states[i] {
+		case "running":
+			running++
+			if status == trayIdle {
+				status = trayRunning
+			}
+		case "failed":
+			status = trayFailed
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.toggle != nil {
+		if t.dashboard.isVisible() {
+			t.toggle.SetTitle("Hide dashboard")
+		} else {
+			t.toggle.SetTitle("Show dashboard")
+		}
+	}
+
+	sig := []string{fmt.Sprint(len(conns))}
+	for i, c := range conns {
+		sig = append(sig, fmt.Sprintf("%s %s %s", c.ID, c.Name, states[i]))
+	}
+	current := strings.Join(sig, "\n")
+	if t.last == current {
+		return
+	}
+	// The dashboard is redrawn when a connection changes outside of it, e.g. if it fails
+	if t.last != "" {
+		go t.dashboard.Reload(t.app)
+	}
+	t.last = current
+
+	systray.SetIcon(t.icon(status))
+	systray.SetTooltip(fmt.Sprintf("EdgeVPN: %d of %d connections running", running, len(conns)))
+	if t.conns == nil {
+		return
+	}
+
+	showItem(t.conns, len(conns) != 0)
+	if running == 0 {
+		t.stopAll.Disable()
+	} else {
+		t.stopAll.Enable()
+	}
+	for i, c := range conns {
+		if i == len(t.slots) {
+			t.addSlot()
+		}
+		s := t.slots[i]
+		s.conn = c
+		s.item.SetTitle(fmt.Sprintf("%s (%s)", c.Name, states[i]))
+		s.item.Show()
+		showItem(s.start, states[i] != "running")
+		showItem(s.stop, states[i] == "running")
+		showItem(s.logs, states[i] != "stopped")
+	}
+	for _, s := range t.slots[len(conns):] {
+		s.conn = nil
+		s.item.Hide()
+	}
+}
+
+func (t *tray) stopAllConnections() {
+	stopped := 0
+	for _, c := range loadConnections(t.dashboard) {
+		if c.isAlive() {
+			c.kill()
+			stopped++
+		}
+	}
+	if stopped != 0 {
+		t.app.SendNotification(fyne.NewNotification("info", fmt.Sprintf("%d connections stopped", stopped)))
+	}
+	t.dashboard.Reload(t.app)
+	t.update()
+}
+
+// icon returns the application icon with a badge of the color of status
+func (t *tray) icon(status trayStatus) []byte {
+	if b, ok := t.icons[status]; ok {
+		return b
+	}
+	b, err := badgeIcon(resourceIconPng.Content(), palette[theme.VariantLight][trayColors[status]])
+	if err != nil {
+		log.Println("Failed creating the tray icon:", err)
+		b = resourceIconPng.Content()
+	}
+	t.icons[status] = b
+	return b
+}
+
+// badgeIcon draws a circle of color c in the bottom right corner of the PNG icon
+func badgeIcon(icon []byte, c color.Color) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(icon))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	img := image.NewNRGBA(b)
+	draw.Draw(img, b, src, b.Min, draw.Src)
+
+	r := b.Dx() / 5
+	cx, cy := b.Max.X-r-1, b.Max.Y-r-1
+	border := r + r/4
+	for y := cy - border; y <= cy+border; y++ {
+		for x := cx - border; x <= cx+border; x++ {
+			d := (x-cx)*(x-cx) + (y-cy)*(y-cy)
+			switch {
+			case d <= r*r:
+				img.Set(x, y, c)
+			case d <= border*border:
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
 	}
+	return out.Bytes(), nil
 }
@@ -0,0 +1,245 @@
+// Copyright © 2021 Ettore Di Giacinto <mudler@mocaccino.org>
+//
+// This program is free software; you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation; either version 2 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program; if not, see <http://www.gnu.org/licenses/>.
+
+package gui
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const updateCheckInterval = 6 * time.Hour
+
+type updateChecker struct {
+	app       fyne.App
+	dashboard *dashboard
+	interval  time.Duration
+
+	notified string
+}
+
+func newUpdateChecker(app fyne.App, d *dashboard) *updateChecker {
+	return &updateChecker{
+		app:       app,
+		dashboard: d,
+		interval:  updateCheckInterval,
+	}
+}
+
+// newestLocalVersion returns the newest EdgeVPN version found locally, among the
+// downloaded versions and the system one.
+func newestLocalVersion() string {
+	newest := latestInstalledVersion()
+	if isInstalled("edgevpn") {
+		if v, err := probeVersion("edgevpn"); err == nil && (newest == "" || compareVersions(v, newest) > 0) {
+			newest = v
+		}
+	}
+	return newest
+}
+
+// latest returns the newest stable EdgeVPN release if it is newer than
+// any version installed locally, including the system one.
+func (u *updateChecker) latest(ctx context.Context) (string, error) {
+	s := currentSettings()
+	f := newReleaseFinder(ctx, s.GitHubToken)
+	rel, _, err := f.find(s.ReleaseRepository, "")
+	if err != nil {
+		return "", err
+	}
+	if rel == nil {
+		return "", nil
+	}
+
+	v := rel.GetName()
+	if installed := newestLocalVersion(); installed != "" && compareVersions(v, installed) <= 0 {
+		return "", nil
+	}
+	return v, nil
+}
+
+func (u *updateChecker) check(ctx context.Context) {
+	if !currentSettings().UpdateChecks {
+		return
+	}
+	v, err := u.latest(ctx)
+	if err != nil {
+		log.Println("Failed checking for updates:", err)
+		return
+	}
+	if v == "" || v == u.notified {
+		return
+	}
+
+	u.notified = v
+	u.dashboard.setUpdateAvailable(u.app, v)
+	u.app.SendNotification(
+		fyne.NewNotification(
+			"update available",
+			fmt.Sprintf("EdgeVPN %s is available", v),
+		))
+}
+
+// start checks for updates periodically until ctx is cancelled
+func (u *updateChecker) start(ctx context.Context) {
+	go func() {
+		t := time.NewTicker(u.interval)
+		defer t.Stop()
+
+		u.check(ctx)
+		for {
+			select {
+			case <-t.C:
+				u.check(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+type upgrade struct {
+	app       fyne.App
+	dashboard *dashboard
+	version   string
+
+	window fyne.Window
+}
+
+func newUpgrade(app fyne.App, d *dashboard, version string) *upgrade {
+	return &upgrade{
+		app:       app,
+		dashboard: d,
+		version:   version,
+	}
+}
+
+// run installs the release and switches the selected connections to it.
+// It returns an error if the release can't be installed.
+func (u *upgrade) run(selected []*vpn, status *widget.Label) error {
+	if !inSlice(u.version, availableVersions()) {
+		status.SetText(fmt.Sprintf("Downloading %s...", u.version))
+		done := make(chan error, 1)
+		DownloadAndInstall(u.app, u.window, u.version, func(err error) {
+			done <- err
+		})
+		if err := <-done; err != nil {
+			status.SetText(fmt.Sprintf("Failed installing %s", u.version))
+			return err
+		}
+	}
+
+	failed := []string{}
+	for _, c := range selected {
+		status.SetText(fmt.Sprintf("Switching '%s' to %s...", c.Name, u.version))
+		if err := c.switchVersion(u.app, u.version); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, err.Error()))
+		}
+	}
+
+	u.dashboard.setUpdateAvailable(u.app, "")
+
+	if len(failed) != 0 {
+		status.SetText("Upgrade completed with errors")
+		errorWindow(fmt.Errorf("failed upgrading connections:\n%s", strings.Join(failed, "\n")), u.window)
+		return nil
+	}
+
+	status.SetText("Upgrade completed")
+	u.app.SendNotification(
+		fyne.NewNotification(
+			"upgrade successful",
+			fmt.Sprintf("EdgeVPN %s installed", u.version),
+		))
+	return nil
+}
+
+func (u *upgrade) showUI() {
+	u.window = u.app.NewWindow(fmt.Sprintf("Upgrade to %s", u.version))
+
+	connections := u.dashboard.connections()
+	checks := []*widget.Check{}
+	items := []fyne.CanvasObject{}
+	for _, c := range connections {
+		current := c.RuntimeVersion
+		if current == "" {
+			current = "system"
+		}
+		label := fmt.Sprintf("%s (%s)", c.Name, current)
+		if c.isAlive() {
+			label += ", running"
+		}
+		check := widget.NewCheck(label, func(bool) {})
+		// Pre-select connections pinned to older versions
+		check.SetChecked(c.RuntimeVersion != "" && compareVersions(c.RuntimeVersion, u.version) < 0)
+		checks = append(checks, check)
+		items = append(items, check)
+	}
+
+	status := widget.NewLabel("")
+	text := widget.NewLabel(
+		fmt.Sprintf("EdgeVPN %s will be installed. Select the connections to switch to the new version: running connections will be restarted, and rolled back if they fail to start.", u.version),
+	)
+	text.Wrapping = fyne.TextWrapWord
+
+	var upgradeButton *widget.Button
+	upgradeButton = widget.NewButtonWithIcon("Upgrade",
+		theme.ViewRefreshIcon(),
+		func() {
+			selected := []*vpn{}
+			for i, c := range checks {
+				if c.Checked {
+					selected = append(selected, connections[i])
+				}
+			}
+			dialog.NewConfirm(
+				"Upgrade",
+				fmt.Sprintf("Are you sure you want to upgrade %d connection(s) to %s?", len(selected), u.version),
+				func(b bool) {
+					if b {
+						upgradeButton.Disable()
+						go func() {
+							// The download can be tried again
+							if err := u.run(selected, status); err != nil {
+								upgradeButton.Enable()
+							}
+						}()
+					}
+				}, u.window,
+			).Show()
+		})
+	upgradeButton.Importance = widget.HighImportance
+
+	u.window.SetContent(
+		container.NewBorder(
+			text,
+			container.NewVBox(status, upgradeButton),
+			nil,
+			nil,
+			container.NewVScroll(container.NewVBox(items...)),
+		),
+	)
+	u.window.Resize(fyne.NewSize(480, 360))
+	u.window.Show()
+}
@@ -19,12 +19,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
+	process "github.com/mudler/go-processmanager"
+)
+
+const (
+	startupGracePeriod = 2 * time.Second
+
+	// customRuntime is selected when the connection uses an arbitrary EdgeVPN binary
+	customRuntime = "custom"
+
+	// lastUsedFile keeps the time the connection was last started, in the profile directory
+	lastUsedFile = "last_used"
 )
 
 type parent interface {
@@ -32,13 +48,34 @@ type parent interface {
 }
 
 type vpn struct {
+	ID             string `json:"id,omitempty"`
 	Name           string `json:"name"`
 	Token          string `json:"token"`
 	IP             string `json:"ip"`
+	IPPool         string `json:"ip_pool,omitempty"`
 	API            bool   `json:"api"`
 	APIAddress     string `json:"api_address"`
 	Interface      string `json:"interface"`
 	RuntimeVersion string `json:"runtime_version"`
+	RuntimePath    string `json:"runtime_path,omitempty"`
+	Mode           string `json:"mode,omitempty"`
+	ProxyAddress   string `json:"proxy_address,omitempty"`
+
+	Services   []service `json:"services,omitempty"`
+	ReceiveDir string    `json:"receive_dir,omitempty"`
+
+	DNS               bool     `json:"dns,omitempty"`
+	DNSAddress        string   `json:"dns_address,omitempty"`
+	DNSForwarder      bool     `json:"dns_forwarder,omitempty"`
+	DNSForwardServers []string `json:"dns_forward_servers,omitempty"`
+	SplitDNS          bool     `json:"split_dns,omitempty"`
+	SplitDNSDomain    string   `json:"split_dns_domain,omitempty"`
+
+	// Tags group the connection on the dashboard
+	Tags []string `json:"tags,omitempty"`
+	// LastUsed is the time the connection was last started, in seconds since the epoch.
+	// It is local to the machine, and stored out of the profile.
+	LastUsed int64 `json:"-"`
 
 	stateDir string
 
@@ -46,13 +83,27 @@ type vpn struct {
 	parent parent
 }
 
-func (c *vpn) writeJSON(name string) error {
+// writeJSON saves the profile in the directory of its ID. Profiles without
+// an ID are new, and get one.
+func (c *vpn) writeJSON() error {
 	if err := c.validate(); err != nil {
 		return err
 	}
-	os.MkdirAll(filepath.Join(stateDir(), name), os.ModePerm)
+	if c.ID == "" {
+		id, err := newProfileID()
+		if err != nil {
+			return err
+		}
+		c.ID = id
+	}
+	if !validID(c.ID) {
+		return fmt.Errorf("invalid profile ID '%s'", c.ID)
+	}
+	if err := checkName(c.ID, c.Name); err != nil {
+		return err
+	}
 
-	if c.RuntimeVersion == "system" {
+	if c.RuntimeVersion == "system" || c.RuntimeVersion == customRuntime {
 		c.RuntimeVersion = ""
 	}
 
@@ -60,43 +111,261 @@ func (c *vpn) writeJSON(name string) error {
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(stateDir(), name, "data"), dat, os.ModePerm)
+	c.stateDir = profileDir(c.ID)
+	os.MkdirAll(c.stateDir, os.ModePerm)
+	return os.WriteFile(filepath.Join(c.stateDir, "data"), dat, os.ModePerm)
 }
 
 func (c *vpn) loadJSON() *vpn {
 	t, _ := ioutil.ReadFile(filepath.Join(c.stateDir, "data"))
-	json.Unmarshal(t, c)
+	// Start from a clean state, so fields omitted from the file are reset
+	d := vpn{
+		stateDir: c.stateDir,
+		window:   c.window,
+		parent:   c.parent,
+	}
+	json.Unmarshal(t, &d)
+	// The directory is authoritative, the profile might have been copied
+	if c.stateDir != "" {
+		d.ID = filepath.Base(c.stateDir)
+	}
+	if dat, err := ioutil.ReadFile(filepath.Join(c.stateDir, lastUsedFile)); err == nil {
+		d.LastUsed, _ = strconv.ParseInt(strings.TrimSpace(string(dat)), 10, 64)
+	}
+	*c = d
 	return c
 }
 
-func generateToken(app fyne.App, w fyne.Window) string {
-	available := availableVersions()
-	if !isInstalled("edgevpn") && len(available) == 0 {
-		errorWindow(
-			fmt.Errorf("can't generate a new token as EdgeVPN is not installed, and no versions were downloaded"), w)
-		return ""
+// requireRuntime returns an error if no EdgeVPN runtime is available to run connections
+func requireRuntime() error {
+	if !isInstalled("edgevpn") && len(availableVersions()) == 0 {
+		return fmt.Errorf("EdgeVPN is not installed and no versions were downloaded: download a version from the version manager first")
+	}
+	return nil
+}
+
+// binary returns the EdgeVPN binary used to run the connection
+func (c *vpn) binary() (string, error) {
+	if c.RuntimePath != "" {
+		if !isExecutable(c.RuntimePath) {
+			return "", fmt.Errorf("'%s' is not an executable", c.RuntimePath)
+		}
+		return c.RuntimePath, nil
 	}
 
-	bin := "edgevpn"
+	if c.RuntimeVersion != "" {
+		if !inSlice(c.RuntimeVersion, availableVersions()) {
+			return "", fmt.Errorf("No version found for '%s'", c.RuntimeVersion)
+		}
+		return binaryVersion(c.RuntimeVersion), nil
+	}
 
 	if !isInstalled("edgevpn") {
-		v := available[len(available)-1]
-		bin = binaryVersion(v)
+		return "", fmt.Errorf("edgeVPN is not installed and no versions were downloaded")
 	}
+	return "edgevpn", nil
+}
 
-	token, _ := exec.Command("/bin/sh", "-c", fmt.Sprintf("%s -g -b", bin)).CombinedOutput()
-	return string(token)
+// run starts the connection in the background
+func (c *vpn) run() (*process.Process, error) {
+	if c.isProxy() {
+		return c.runCommand(c.processDir(), c.args()...)
+	}
+
+	bin, err := c.binary()
+	if err != nil {
+		return nil, err
+	}
+
+	processStateDir := c.processDir()
+	os.MkdirAll(processStateDir, os.ModePerm)
+	// No shell is involved: pkexec clears the environment, so the token is set with env
+	vpnP := process.New(
+		process.WithName("/usr/bin/pkexec"),
+		process.WithArgs(append([]string{"env", "EDGEVPNTOKEN=" + c.Token, bin}, c.args()...)...),
+		process.WithStateDir(processStateDir),
+	)
+	if err := vpnP.Run(); err != nil {
+		vpnP.Stop()
+		os.RemoveAll(processStateDir)
+		return nil, err
+	}
+	return vpnP, nil
 }
 
-func (c *vpn) validate() error {
-	_, _, err := net.ParseCIDR(c.IP)
+// kill stops the connection and cleans up its process state
+func (c *vpn) kill() {
+	vpnP := process.New(
+		process.WithStateDir(c.processDir()),
+	)
+	if vpnP.IsAlive() {
+		// Split DNS is reverted while the interface still exists
+		if err := c.revertSplitDNS(); err != nil {
+			log.Println(err)
+		}
+	}
+	vpnP.Stop()
+	// Only VPN connections run as root: proxies are stopped without privileges
+	if vpnP.IsAlive() && !c.isProxy() {
+		exec.Command("/usr/bin/pkexec", "kill", "-9", vpnP.PID).CombinedOutput()
+	}
+	os.RemoveAll(c.processDir())
+}
+
+// runCommand starts an EdgeVPN sub-command for the connection as an unprivileged
+// background process, keeping its state in dir.
+func (c *vpn) runCommand(dir string, args ...string) (*process.Process, error) {
+	bin, err := c.binary()
+	if err != nil {
+		return nil, err
+	}
+	// The process manager doesn't look up binaries in $PATH
+	bin, err = exec.LookPath(bin)
 	if err != nil {
+		return nil, err
+	}
+
+	os.MkdirAll(dir, os.ModePerm)
+	p := process.New(
+		process.WithName(bin),
+		process.WithArgs(args...),
+		process.WithEnvironment(append(os.Environ(), fmt.Sprintf("EDGEVPNTOKEN=%s", c.Token))...),
+		process.WithStateDir(dir),
+	)
+	if err := p.Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return p, nil
+}
+
+// stopCommand stops a process started with runCommand and cleans up its state
+func stopCommand(dir string) {
+	process.New(process.WithStateDir(dir)).Stop()
+	os.RemoveAll(dir)
+}
 
+// waitReady waits for the connection to settle after being started
+// and reports whether it is still running.
+func (c *vpn) waitReady() bool {
+	time.Sleep(startupGracePeriod)
+	return c.isAlive()
+}
+
+// restart stops the connection if running, and starts it again.
+// It returns an error if the connection doesn't come up.
+func (c *vpn) restart(app fyne.App) error {
+	c.kill()
+	if _, err := c.run(); err != nil {
+		return err
+	}
+	if !c.waitReady() {
+		c.kill()
+		return fmt.Errorf("connection '%s' failed to start", c.Name)
+	}
+	// The connection is up even if split DNS can't be configured, so it is notified on its own
+	if err := c.configureSplitDNS(); err != nil {
+		app.SendNotification(fyne.NewNotification("error", err.Error()))
+	}
+	return nil
+}
+
+// switchVersion pins the connection to version, restarting it if it was running.
+// If the connection doesn't come back up, the previous version is restored.
+func (c *vpn) switchVersion(app fyne.App, version string) error {
+	previous, previousPath := c.RuntimeVersion, c.RuntimePath
+	running := c.isAlive()
+
+	c.RuntimeVersion, c.RuntimePath = version, ""
+	if err := c.writeJSON(); err != nil {
+		return err
+	}
+	if !running {
+		return nil
+	}
+
+	err := c.restart(app)
+	if err == nil {
+		return nil
+	}
+
+	// Rollback
+	c.RuntimeVersion, c.RuntimePath = previous, previousPath
+	if werr := c.writeJSON(); werr != nil {
+		return fmt.Errorf("%s, and failed restoring version: %w", err.Error(), werr)
+	}
+	if rerr := c.restart(app); rerr != nil {
+		return fmt.Errorf("%s, and failed restarting with previous version: %w", err.Error(), rerr)
+	}
+	return fmt.Errorf("%s, rolled back to the previous version", err.Error())
+}
+
+var (
+	validInterface = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+	validHostname  = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.?$`)
+)
+
+// validateAddress returns an error if addr is not a host:port address. The
+// host can be empty, an IP or a hostname, and the port must be numeric.
+func validateAddress(what, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s address '%s': %w", what, addr, err)
+	}
+	if host != "" && net.ParseIP(host) == nil && !validHostname.MatchString(host) {
+		return fmt.Errorf("invalid %s address '%s': invalid host", what, addr)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return fmt.Errorf("invalid %s address '%s': invalid port", what, addr)
+	}
+	return nil
+}
+
+func (c *vpn) validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("the connection name can't be empty")
+	}
+	if c.isProxy() {
+		if err := c.validateProxy(); err != nil {
+			return err
+		}
+	} else {
+		if _, _, err := net.ParseCIDR(c.IP); err != nil {
+			return err
+		}
+		if !validInterface.MatchString(c.Interface) {
+			return fmt.Errorf("invalid interface name '%s'", c.Interface)
+		}
+		if c.API {
+			if err := validateAddress("API", c.APIAddress); err != nil {
+				return err
+			}
+		}
+	}
+	if c.IPPool != "" {
+		if _, _, err := net.ParseCIDR(c.IPPool); err != nil {
+			return fmt.Errorf("invalid address pool '%s': %w", c.IPPool, err)
+		}
+	}
+	if err := validateServices(c.Services); err != nil {
 		return err
 	}
+	if err := c.validateDNS(); err != nil {
+		return err
+	}
+	if c.RuntimePath != "" && !isExecutable(c.RuntimePath) {
+		return fmt.Errorf("'%s' is not an executable", c.RuntimePath)
+	}
 	return nil
 }
 
+// markUsed records that the connection was started now. The time is written
+// next to the profile, which doesn't need to be validated and saved again.
+func (c *vpn) markUsed() error {
+	c.LastUsed = time.Now().Unix()
+	return ioutil.WriteFile(filepath.Join(c.stateDir, lastUsedFile), []byte(strconv.FormatInt(c.LastUsed, 10)), 0644)
+}
+
 func newVPN(p string, parent parent) *vpn {
 	v := &vpn{
 		parent:   parent,
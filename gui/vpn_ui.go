@@ -17,11 +17,12 @@ package gui
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/layout"
@@ -35,6 +36,18 @@ func (c *vpn) isAlive() bool {
 	return process.New(process.WithStateDir(c.processDir())).IsAlive()
 }
 
+// connectionState returns the state of the connection, and its theme color.
+// A connection is failed when it exited without being stopped.
+func (c *vpn) connectionState() (string, fyne.ThemeColorName) {
+	if c.isAlive() {
+		return "running", colorNameRunning
+	}
+	if _, err := os.Stat(c.processDir()); err == nil {
+		return "failed", colorNameFailed
+	}
+	return "stopped", colorNameStopped
+}
+
 func (c *vpn) processDir() string {
 	return filepath.Join(c.stateDir, "vpn")
 }
@@ -45,46 +58,38 @@ func (c *vpn) showDetails(w fyne.Window, app fyne.App) {
 
 	name := widget.NewEntry()
 	name.SetText(c.Name)
-	name.Disable()
-	ipE := widget.NewEntry()
-	ipE.SetText(c.IP)
+	w.SetTitle(fmt.Sprintf("VPN %s", c.Name))
+	tags := widget.NewEntry()
+	tags.SetText(strings.Join(c.Tags, ", "))
+	tags.SetPlaceHolder("comma separated, e.g. work, lab")
 	token := widget.NewPasswordEntry()
 	token.SetText(c.Token)
-
-	runtimeVersion := widget.NewSelect(selectableVersions(), func(s string) {})
-	runtimeForm := widget.NewFormItem("Runtime version", runtimeVersion)
-	selected := c.RuntimeVersion
-	if selected == "" {
-		selected = "system"
-	}
-	runtimeVersion.SetSelected(selected)
+	ipItems, selectedIP := c.ipForm(w, func() string { return token.Text })
+
+	runtimeItems, selectedRuntime := c.runtimeForm(w)
+
+	detected := widget.NewLabel("")
+	runtimeItems = append(runtimeItems, widget.NewFormItem("Detected runtime", detected))
+	go func() {
+		detected.SetText("probing...")
+		bin, err := c.binary()
+		if err == nil {
+			var version string
+			version, err = probeVersion(bin)
+			if err == nil {
+				detected.SetText(fmt.Sprintf("%s (%s)", version, bin))
+				return
+			}
+		}
+		detected.SetText(err.Error())
+	}()
 
 	v := widget.NewFormItem("VPN Name", name)
-	ip := widget.NewFormItem("IP", ipE)
 
-	s := container.NewHSplit(token, c.tokenClipboardButton(app))
-	s.SetOffset(0.9)
+	s := container.NewHSplit(token, container.NewHBox(c.inspectTokenButton(app, w), c.tokenClipboardButton(app)))
+	s.SetOffset(0.85)
 	tokenW := widget.NewFormItem("Token", s)
 
-	saveDialog := dialog.NewFileSave(
-		func(f fyne.URIWriteCloser, e error) {
-			if e != nil {
-				errorWindow(e, w)
-				return
-			}
-			if f == nil {
-				return
-			}
-			s, err := os.Open(filepath.Join(c.stateDir, "data"))
-			if err != nil {
-				errorWindow(err, w)
-				return
-			}
-			io.Copy(f, s)
-			f.Close()
-			app.SendNotification(fyne.NewNotification("info", "File saved"))
-		}, w)
-
 	iff := widget.NewEntry()
 	iff.SetText(c.Interface)
 	apiText := widget.NewEntry()
@@ -108,8 +113,21 @@ func (c *vpn) showDetails(w fyne.Window, app fyne.App) {
 	ifw := widget.NewFormItem("Interface", iff)
 	api := widget.NewFormItem("API", apiB)
 
+	// The proxy doesn't expose the API, which is shown only for VPN connections
+	modeItems, selectedMode := c.modeForm(func(proxy bool) {
+		if proxy {
+			apiL.Widget.Hide()
+		} else {
+			apiB.OnChanged(apiB.Checked)
+		}
+	}, append(ipItems, ifw, api)...)
+
+	items := append([]*widget.FormItem{v, widget.NewFormItem("Tags", tags)}, modeItems...)
+	items = append(items, ipItems...)
+	items = append(items, ifw, api, apiL)
+	items = append(items, runtimeItems...)
 	form := widget.NewForm(
-		v, ip, ifw, api, apiL, runtimeForm, tokenW,
+		append(items, tokenW)...,
 	)
 
 	buttons := []fyne.CanvasObject{
@@ -117,21 +135,37 @@ func (c *vpn) showDetails(w fyne.Window, app fyne.App) {
 		widget.NewButtonWithIcon("Save",
 			theme.DocumentSaveIcon(),
 			func() {
-				c.update(vpn{
-					Token:          token.Text,
-					IP:             ipE.Text,
-					Name:           name.Text,
-					Interface:      iff.Text,
-					API:            apiB.Checked,
-					APIAddress:     apiText.Text,
-					RuntimeVersion: runtimeVersion.Selected,
-				}, app, w)()
+				d := *c
+				d.Token = token.Text
+				d.IP, d.IPPool = selectedIP()
+				d.Name = name.Text
+				d.Tags = parseTags(tags.Text)
+				d.Interface = iff.Text
+				d.API = apiB.Checked
+				d.APIAddress = apiText.Text
+				d.RuntimeVersion, d.RuntimePath = selectedRuntime()
+				d.Mode, d.ProxyAddress = selectedMode()
+				c.update(d, app, w)()
 			},
 		),
 		widget.NewButtonWithIcon("Export",
 			theme.UploadIcon(),
 			func() {
-				saveDialog.Show()
+				c.exportAs(app, w)
+			}),
+		widget.NewButtonWithIcon("Rotate token",
+			theme.ViewRefreshIcon(),
+			c.rotateToken(app, w),
+		),
+		widget.NewButtonWithIcon("QR code",
+			theme.ComputerIcon(),
+			func() {
+				c.showQR(app)
+			}),
+		widget.NewButtonWithIcon("Invite",
+			theme.MailComposeIcon(),
+			func() {
+				c.showInvite(app, loadConnections(c.parent))
 			}),
 	}
 
@@ -162,7 +196,11 @@ func (c *vpn) showDetails(w fyne.Window, app fyne.App) {
 		nil,
 		nil,
 		nil,
-		container.NewGridWithColumns(1, form),
+		container.NewAppTabs(
+			container.NewTabItem("Connection", container.NewGridWithColumns(1, form)),
+			container.NewTabItem("Services", c.servicesUI(app, w)),
+			container.NewTabItem("DNS", c.dnsUI(app, w)),
+		),
 	))
 	// w.SetContent(container.NewBorder(
 	// 	nil,
@@ -184,6 +222,63 @@ func (c *vpn) showUI(app fyne.App) {
 	c.window.Show()
 }
 
+// runtimeForm returns the form items to select the EdgeVPN runtime of the connection,
+// and a function returning the selected version and custom binary path.
+func (c *vpn) runtimeForm(w fyne.Window) ([]*widget.FormItem, func() (string, string)) {
+	path := widget.NewEntry()
+	path.SetText(c.RuntimePath)
+	path.SetPlaceHolder("/path/to/edgevpn")
+	browse := widget.NewButtonWithIcon("",
+		theme.FolderOpenIcon(),
+		func() {
+			dialog.NewFileOpen(
+				func(f fyne.URIReadCloser, e error) {
+					if e != nil {
+						errorWindow(e, w)
+						return
+					}
+					if f == nil {
+						return
+					}
+					f.Close()
+					path.SetText(f.URI().Path())
+				}, w).Show()
+		})
+	pathForm := widget.NewFormItem("Runtime path", container.NewBorder(nil, nil, nil, browse, path))
+
+	runtimeVersion := widget.NewSelect(append(selectableVersions(), customRuntime), func(s string) {
+		if s == customRuntime {
+			pathForm.Widget.Show()
+		} else {
+			pathForm.Widget.Hide()
+		}
+	})
+
+	selected := c.RuntimeVersion
+	switch {
+	case c.RuntimePath != "":
+		selected = customRuntime
+	case selected == "":
+		selected = "system"
+	}
+	runtimeVersion.SetSelected(selected)
+	if selected != customRuntime {
+		pathForm.Widget.Hide()
+	}
+
+	selectedRuntime := func() (string, string) {
+		if runtimeVersion.Selected == customRuntime {
+			return "", path.Text
+		}
+		return runtimeVersion.Selected, ""
+	}
+
+	return []*widget.FormItem{
+		widget.NewFormItem("Runtime version", runtimeVersion),
+		pathForm,
+	}, selectedRuntime
+}
+
 func selectableVersions() []string {
 	if isInstalled("edgevpn") {
 		return append([]string{"system"}, availableVersions()...)
@@ -194,17 +289,21 @@ func selectableVersions() []string {
 func (c *vpn) generateUI(app fyne.App, genToken bool) {
 	c.window = app.NewWindow("VPN")
 	name := widget.NewEntry()
-	ipE := widget.NewEntry()
+	name.SetText(c.Name)
 	v := widget.NewFormItem("VPN Name", name)
-	ip := widget.NewFormItem("IP", ipE)
+	tags := widget.NewEntry()
+	tags.SetText(strings.Join(c.Tags, ", "))
+	tags.SetPlaceHolder("comma separated, e.g. work, lab")
 	iff := widget.NewEntry()
 
-	runtimeVersion := widget.NewSelect(selectableVersions(), func(string) {})
-	runtimeForm := widget.NewFormItem("Runtime version", runtimeVersion)
+	runtimeItems, selectedRuntime := c.runtimeForm(c.window)
 
 	apiText := widget.NewEntry()
 	apiL := widget.NewFormItem("API Listen Address", apiText)
-	apiText.Text = ":8080"
+	apiText.Text = currentSettings().DefaultAPIAddress
+	if c.APIAddress != "" {
+		apiText.Text = c.APIAddress
+	}
 
 	apiL.Widget.Hide()
 	apiB := widget.NewCheck("API", func(c bool) {
@@ -216,42 +315,97 @@ func (c *vpn) generateUI(app fyne.App, genToken bool) {
 		}
 	})
 
+	apiB.SetChecked(c.API)
+
 	ifw := widget.NewFormItem("Interface", iff)
 	api := widget.NewFormItem("API", apiB)
 
-	iff.Text = "edgevpn0"
+	iff.Text = currentSettings().DefaultInterface
+	if c.Interface != "" {
+		iff.Text = c.Interface
+	}
 	token := widget.NewPasswordEntry()
+	token.SetText(c.Token)
 	tk := widget.NewFormItem("Token", token)
 
+	// When generating a new network, the token is built from its settings on submit
+	tokenItems := []*widget.FormItem{tk}
+	tokenFromSettings := func() (string, error) { return token.Text, nil }
 	if genToken {
-		token.Disable()
-		token.SetText(generateToken(app, c.window))
+		tokenItems, tokenFromSettings = tokenForm()
 	}
 
+	// A generated token is a new network, with no peers to check addresses against
+	ipItems, selectedIP := c.ipForm(c.window, func() string {
+		if genToken {
+			return ""
+		}
+		return token.Text
+	})
+
+	// The proxy doesn't expose the API, which is shown only for VPN connections
+	modeItems, selectedMode := c.modeForm(func(proxy bool) {
+		if proxy {
+			apiL.Widget.Hide()
+		} else {
+			apiB.OnChanged(apiB.Checked)
+		}
+	}, append(ipItems, ifw, api)...)
+
+	items := append([]*widget.FormItem{v, widget.NewFormItem("Tags", tags)}, modeItems...)
+	items = append(items, ipItems...)
+	items = append(items, tokenItems...)
+	items = append(items, ifw, api, apiL)
 	form := widget.NewForm(
-		v, ip, tk, ifw, api, apiL, runtimeForm,
+		append(items, runtimeItems...)...,
 	)
 
 	form.OnCancel = func() {
 		c.window.Close()
 	}
 	form.OnSubmit = func() {
-		d := vpn{
-			Token:          token.Text,
-			IP:             ipE.Text,
-			Name:           name.Text,
-			Interface:      iff.Text,
-			API:            apiB.Checked,
-			APIAddress:     apiText.Text,
-			RuntimeVersion: runtimeVersion.Selected,
-		}
-		if err := d.writeJSON(name.Text); err != nil {
+		t, err := tokenFromSettings()
+		if err != nil {
 			errorWindow(err, c.window)
 			return
 		}
+		// Start from the connection, so settings of imported profiles are kept,
+		// but always save it as a new profile
+		d := *c
+		d.ID = ""
+		d.stateDir = ""
+		d.Token = t
+		d.IP, d.IPPool = selectedIP()
+		d.Name = name.Text
+		d.Tags = parseTags(tags.Text)
+		d.Interface = iff.Text
+		d.API = apiB.Checked
+		d.APIAddress = apiText.Text
+		d.RuntimeVersion, d.RuntimePath = selectedRuntime()
+		d.Mode, d.ProxyAddress = selectedMode()
+
+		save := func() {
+			if err := d.writeJSON(); err != nil {
+				errorWindow(err, c.window)
+				return
+			}
 
-		c.parent.Reload(app)
-		c.window.Close()
+			c.parent.Reload(app)
+			c.window.Close()
+		}
+
+		if owner := d.ipCollision(loadConnections(c.parent)); owner != "" {
+			dialog.NewConfirm(
+				"IP already in use",
+				fmt.Sprintf("%s is already used by '%s'. Save anyway?", d.IP, owner),
+				func(b bool) {
+					if b {
+						save()
+					}
+				}, c.window).Show()
+			return
+		}
+		save()
 	}
 
 	c.window.SetContent(form)
@@ -260,6 +414,42 @@ func (c *vpn) generateUI(app fyne.App, genToken bool) {
 	c.window.Show()
 }
 
+// subtitle returns the address of the connection shown on the dashboard
+func (c *vpn) subtitle() string {
+	if c.isProxy() {
+		return fmt.Sprintf("Proxy %s", c.proxyURL())
+	}
+	return c.IP
+}
+
+// row returns the connection as a row of the compact list view of the dashboard
+func (c *vpn) row(app fyne.App, w fyne.Window) fyne.CanvasObject {
+	state, stateColor := c.connectionState()
+	dot := container.NewGridWrap(fyne.NewSize(theme.Padding()*3, theme.Padding()*3),
+		canvas.NewCircle(themeColor(stateColor)))
+
+	actions := []fyne.CanvasObject{}
+	if c.isAlive() {
+		actions = append(actions, c.stopButton(app, w), c.logButton(app))
+	} else {
+		actions = append(actions, c.startButton(app, nil, widget.LowImportance))
+	}
+	info := widget.NewButtonWithIcon("",
+		theme.SettingsIcon(),
+		func() {
+			c.showUI(app)
+		},
+	)
+	info.Importance = widget.LowImportance
+	actions = append(actions, info)
+
+	return container.NewBorder(nil, nil,
+		container.NewHBox(container.NewCenter(dot), widget.NewLabelWithStyle(c.Name, fyne.TextAlignLeading, fyne.TextStyle{Bold: true})),
+		container.NewHBox(actions...),
+		widget.NewLabel(fmt.Sprintf("%s - %s", c.subtitle(), state)),
+	)
+}
+
 func (c *vpn) card(app fyne.App, w fyne.Window) fyne.CanvasObject {
 	var objs []fyne.CanvasObject
 
@@ -280,10 +470,14 @@ func (c *vpn) card(app fyne.App, w fyne.Window) fyne.CanvasObject {
 			c.stopButton(app, w),
 			c.logButton(app),
 		)
-		if c.API {
+		if c.isProxy() {
+			objs = append(objs, c.proxyClipboardButton(app))
+		}
+		if c.API && !c.isProxy() {
 			if l := c.apiLink(); l != nil {
 				objs = append(objs, l)
 			}
+			objs = append(objs, c.ledgerButton(app))
 		}
 	} else {
 		objs = append(objs,
@@ -291,13 +485,28 @@ func (c *vpn) card(app fyne.App, w fyne.Window) fyne.CanvasObject {
 		)
 	}
 
-	return widget.NewCard(
-		c.Name, c.IP,
-		container.NewVBox(
-			container.NewHBox(
-				objs...,
+	objs = append(objs,
+		c.sendFileButton(app, w),
+		c.receiveFileButton(app, w),
+	)
+	if len(c.transfers()) != 0 {
+		objs = append(objs, c.transfersButton(app))
+	}
+
+	state, stateColor := c.connectionState()
+	subtitle := fmt.Sprintf("%s - %s", c.subtitle(), state)
+	stripe := canvas.NewRectangle(themeColor(stateColor))
+	stripe.SetMinSize(fyne.NewSize(theme.Padding(), 0))
+
+	return container.NewBorder(nil, nil, stripe, nil,
+		widget.NewCard(
+			c.Name, subtitle,
+			container.NewVBox(
+				container.NewHBox(
+					objs...,
+				),
+				layout.NewSpacer(),
 			),
-			layout.NewSpacer(),
 		),
 	)
 }
@@ -18,11 +18,9 @@ package gui
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -96,97 +94,54 @@ func (c *vpn) startButton(app fyne.App, w fyne.Window, i widget.ButtonImportance
 }
 
 func (c *vpn) start(app fyne.App, w fyne.Window) func() {
-	var apiCmd string
-	if c.API {
-		apiCmd = fmt.Sprintf("--api --api-listen %s", c.APIAddress)
-	}
-
-	processStateDir := filepath.Join(c.stateDir, "vpn")
-
 	return func() {
-		bin := "edgevpn"
-		if c.RuntimeVersion != "" {
-			vers := availableVersions()
-			match := false
-			for _, v := range vers {
-				if v == c.RuntimeVersion {
-					match = true
-				}
-			}
-			if !match {
-				errorWindow(fmt.Errorf("No version found for '%s'", c.RuntimeVersion), w)
-			} else {
-				bin = binaryVersion(c.RuntimeVersion)
-			}
-		}
-
-		if c.RuntimeVersion == "" && !isInstalled("edgevpn") {
-			errorWindow(fmt.Errorf("edgeVPN is not installed and no versions were downloaded"), w)
+		if _, err := c.run(); err != nil {
+			errorWindow(err, w)
 			return
 		}
-
-		os.MkdirAll(processStateDir, os.ModePerm)
-		vpnP := process.New(
-			process.WithName("/usr/bin/pkexec"),
-			process.WithArgs("/bin/sh", "-c",
-				fmt.Sprintf(
-					"EDGEVPNTOKEN=%s %s --address %s --interface %s %s",
-					c.Token,
-					bin,
-					c.IP,
-					c.Interface,
-					apiCmd,
-				),
-			),
-			process.WithStateDir(processStateDir),
-		)
-		err := vpnP.Run()
-		if err != nil {
-			errorWindow(err, w)
-			vpnP.Stop()
-			os.RemoveAll(processStateDir)
+		if err := c.markUsed(); err != nil {
+			log.Println("Failed recording the last use of", c.Name, err)
 		}
 
 		go func() {
-			time.Sleep(2 * time.Second)
+			ready := c.waitReady()
 			c.parent.Reload(app)
 			if w != nil {
 				c.showDetails(w, app)
 			}
-			if vpnP.IsAlive() {
+			if ready && c.isProxy() {
+				app.SendNotification(
+					fyne.NewNotification(
+						"connection successful",
+						fmt.Sprintf("Proxy to network '%s' listening on '%s'", c.Name, c.ProxyAddress)))
+			} else if ready {
 				app.SendNotification(
 					fyne.NewNotification(
 						"connection successful",
 						fmt.Sprintf("Network '%s' started on interface '%s'", c.Name, c.Interface)))
+				if err := c.configureSplitDNS(); err != nil {
+					errorWindow(err, w)
+				}
 			} else {
 				app.SendNotification(
 					fyne.NewNotification(
 						"connection failed",
 						fmt.Sprintf("failed starting VPN '%s'", c.Name),
 					))
-				vpnP.Stop()
-				os.RemoveAll(processStateDir)
+				c.kill()
 			}
 		}()
 	}
 }
 
 func (c *vpn) stop(app fyne.App, w fyne.Window) func() {
-	processStateDir := filepath.Join(c.stateDir, "vpn")
 	return func() {
 		dialog.NewConfirm(
 			"Stop",
 			"Are you sure you want to stop the VPN?",
 			func(b bool) {
 				if b {
-					vpnP := process.New(
-						process.WithStateDir(processStateDir),
-					)
-					vpnP.Stop()
-					if vpnP.IsAlive() {
-						exec.Command("/usr/bin/pkexec", "kill", "-9", vpnP.PID).CombinedOutput()
-					}
-					os.RemoveAll(processStateDir)
+					c.kill()
 					go func() {
 						time.Sleep(2 * time.Second)
 						c.parent.Reload(app)
@@ -207,11 +162,7 @@ func (c *vpn) logButton(app fyne.App) *widget.Button {
 }
 
 func (c *vpn) apiLink() *widget.Button {
-	a := c.APIAddress
-	if strings.HasPrefix(a, ":") {
-		a = fmt.Sprintf("http://127.0.0.1%s", a)
-	}
-	if u, err := url.Parse(a); err == nil {
+	if u, err := url.Parse(c.apiURL()); err == nil {
 		b := widget.NewButtonWithIcon("API",
 			theme.ComputerIcon(),
 			func() {
@@ -241,6 +192,8 @@ func (c *vpn) delete(app fyne.App, p fyne.Window) func() {
 			"Are you sure you want to delete the VPN?",
 			func(b bool) {
 				if b {
+					c.stopServices()
+					c.stopTransfers()
 					os.RemoveAll(c.stateDir)
 					c.parent.Reload(app)
 					p.Close()
@@ -259,12 +212,16 @@ func (c *vpn) clipboard(app fyne.App) func() {
 
 func (c *vpn) update(dat vpn, app fyne.App, w fyne.Window) func() {
 	return func() {
+		msg := "Are you sure you want to update the VPN?"
+		if owner := dat.ipCollision(loadConnections(c.parent)); owner != "" {
+			msg = fmt.Sprintf("%s is already used by '%s'.\n%s", dat.IP, owner, msg)
+		}
 		dialog.NewConfirm(
 			"Update",
-			"Are you sure you want to update the VPN?",
+			msg,
 			func(b bool) {
 				if b {
-					if err := dat.writeJSON(dat.Name); err != nil {
+					if err := dat.writeJSON(); err != nil {
 						errorWindow(err, w)
 						return
 					}